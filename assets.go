@@ -0,0 +1,12 @@
+// Package ets runs ets Lua scripts from Go programs with a Runner and
+// contains the files that are bundled with the ets binary.
+package ets
+
+import "embed"
+
+// Skeleton contains the files of a new project: the startup file, the
+// sample scripts from the Readme, the Crimson Pro fonts, the en-us
+// hyphenation patterns and the sample image.
+//
+//go:embed ets.lua fonts hyphenationpatterns/hyph-en-us.pat.txt img samples
+var Skeleton embed.FS
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Value describes an argument, a return value or a field. Type is a type in
+// the notation of the Lua language server, such as string, number, table,
+// node or doc|false.
+type Value struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Optional    bool   `json:"optional,omitempty"`
+	Description string `json:"description"`
+}
+
+// Function describes a function of a library or of an object.
+type Function struct {
+	Name        string  `json:"name"`
+	Args        []Value `json:"args,omitempty"`
+	Returns     []Value `json:"returns,omitempty"`
+	Description string  `json:"description"`
+}
+
+// Field describes a field of a library or of an object.
+type Field struct {
+	Value
+	Read  bool `json:"read"`
+	Write bool `json:"write"`
+}
+
+// Class describes a library (Global is the name of the global variable) or a
+// kind of object such as doc or glyphnode. NodeType is the name of the node
+// type in node.new() for classes of nodes.
+type Class struct {
+	Name        string     `json:"name"`
+	Global      string     `json:"global,omitempty"`
+	NodeType    string     `json:"nodetype,omitempty"`
+	Parent      string     `json:"parent,omitempty"`
+	Description string     `json:"description"`
+	Functions   []Function `json:"functions,omitempty"`
+	Fields      []Field    `json:"fields,omitempty"`
+}
+
+// A luaFunction is a function of a library.
+type luaFunction struct {
+	Function
+	fn lua.LGFunction
+}
+
+// A luaMethod is a function of an object. fn returns the function for the
+// object at stack position 1.
+type luaMethod struct {
+	Function
+	fn func(l *lua.LState) lua.LGFunction
+}
+
+// A luaField is a field of an object. index pushes the value of the field of
+// the object at stack position 1 and newindex sets the field to the value at
+// stack position 3. A nil function means the field cannot be read or set.
+type luaField struct {
+	Value
+	index    lua.LGFunction
+	newindex lua.LGFunction
+}
+
+// A luaLibrary is a global table with functions such as document or node.
+type luaLibrary struct {
+	// name is the global variable
+	name string
+	// className is the type of the global variable in the annotations
+	className   string
+	description string
+	functions   []*luaFunction
+	// fields are set outside of the library registration
+	fields []Value
+}
+
+// function returns the function with the name or nil.
+func (lib *luaLibrary) function(name string) *luaFunction {
+	for _, f := range lib.functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// names returns the names of the functions and fields.
+func (lib *luaLibrary) names() []string {
+	var names []string
+	for _, f := range lib.functions {
+		names = append(names, f.Name)
+	}
+	for _, f := range lib.fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// register creates the global table with the library functions.
+func (lib *luaLibrary) register(l *lua.LState) *lua.LTable {
+	mt := l.NewTypeMetatable(lib.name)
+	l.SetGlobal(lib.name, mt)
+	for _, f := range lib.functions {
+		l.SetField(mt, f.Name, l.NewFunction(profiled(lib.name+"."+f.Name, f.fn)))
+	}
+	return mt
+}
+
+// A luaClass is a kind of object (a user data value) with methods and fields.
+type luaClass struct {
+	// name is the name of the metatable and the type in the annotations
+	name        string
+	parent      *luaClass
+	description string
+	methods     []*luaMethod
+	fields      []*luaField
+}
+
+// method returns the method with the name or nil.
+func (c *luaClass) method(name string) *luaMethod {
+	for ; c != nil; c = c.parent {
+		for _, m := range c.methods {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// field returns the field with the name or nil.
+func (c *luaClass) field(name string) *luaField {
+	for ; c != nil; c = c.parent {
+		for _, f := range c.fields {
+			if f.Name == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// names returns the names of the methods and fields that can be read (or set
+// if write is true), including the ones of the parent class.
+func (c *luaClass) names(write bool) []string {
+	var names []string
+	for ; c != nil; c = c.parent {
+		if !write {
+			for _, m := range c.methods {
+				names = append(names, m.Name)
+			}
+		}
+		for _, f := range c.fields {
+			if write && f.newindex != nil || !write && f.index != nil {
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
+
+// metatable returns the metatable for objects of the class.
+func (c *luaClass) metatable(l *lua.LState) *lua.LTable {
+	mt := l.NewTypeMetatable(c.name)
+	if mt.RawGetString("__index") == lua.LNil {
+		l.SetField(mt, "__index", l.NewFunction(c.index))
+		l.SetField(mt, "__newindex", l.NewFunction(c.newindex))
+	}
+	return mt
+}
+
+// newUserData returns a Lua object of the class for the value v.
+func (c *luaClass) newUserData(l *lua.LState, v interface{}) *lua.LUserData {
+	ud := l.NewUserData()
+	ud.Value = v
+	l.SetMetatable(ud, c.metatable(l))
+	return ud
+}
+
+func (c *luaClass) index(l *lua.LState) int {
+	name := l.ToString(2)
+	if m := c.method(name); m != nil {
+		l.Push(l.NewFunction(profiled(c.name+"."+name, m.fn(l))))
+		return 1
+	}
+	if f := c.field(name); f != nil && f.index != nil {
+		return f.index(l)
+	}
+	l.ArgError(2, fmt.Sprintf("unknown field %s in %s", name, c.name))
+	return 0
+}
+
+func (c *luaClass) newindex(l *lua.LState) int {
+	name := l.ToString(2)
+	if f := c.field(name); f != nil && f.newindex != nil {
+		return f.newindex(l)
+	}
+	l.ArgError(2, fmt.Sprintf("unknown field %s in %s", name, c.name))
+	return 0
+}
+
+// luaClasses are all classes except the node types in the order of the
+// reference.
+var luaClasses = []*luaClass{
+	docClass,
+	pageClass,
+	faceClass,
+	fontClass,
+	fontfamilyClass,
+	imagefileClass,
+	imageClass,
+	langClass,
+	nodeClass,
+}
+
+func (lib *luaLibrary) describe() Class {
+	cls := Class{
+		Name:        lib.className,
+		Global:      lib.name,
+		Description: lib.description,
+	}
+	for _, f := range lib.functions {
+		cls.Functions = append(cls.Functions, f.Function)
+	}
+	for _, f := range lib.fields {
+		cls.Fields = append(cls.Fields, Field{Value: f, Read: true})
+	}
+	return cls
+}
+
+func (c *luaClass) describe() Class {
+	cls := Class{
+		Name:        c.name,
+		Description: c.description,
+	}
+	if c.parent != nil {
+		cls.Parent = c.parent.name
+	}
+	for _, m := range c.methods {
+		cls.Functions = append(cls.Functions, m.Function)
+	}
+	for _, f := range c.fields {
+		cls.Fields = append(cls.Fields, Field{
+			Value: f.Value,
+			Read:  f.index != nil,
+			Write: f.newindex != nil,
+		})
+	}
+	return cls
+}
+
+// API returns the description of the libraries, the objects and the node
+// types of ets. This is the same information that is used to register the
+// functions in the Lua state.
+func API() []Class {
+	classes := []Class{documentLibrary.describe(), jsonLibrary.describe()}
+	for _, c := range luaClasses {
+		if c == nodeClass {
+			classes = append(classes, nodeLibrary.describe())
+		}
+		classes = append(classes, c.describe())
+	}
+	var names []string
+	for name := range nodeTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cls := nodeTypes[name].class.describe()
+		cls.NodeType = name
+		classes = append(classes, cls)
+	}
+	return classes
+}
@@ -0,0 +1,496 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// The kind of a value is the name of its class in the binding metadata, such
+// as documentlib, doc or glyphnode. kindUnknown is used for all other values.
+const kindUnknown = ""
+
+// A CheckMessage is a problem found by Check. Column is the position of the
+// field or function name in the line starting at 1, or 0 if it is not known.
+type CheckMessage struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (cm CheckMessage) String() string {
+	return fmt.Sprintf("%s:%d: %s", cm.Filename, cm.Line, cm.Message)
+}
+
+type checker struct {
+	filename string
+	lines    []string
+	messages []CheckMessage
+	// target is a line number. envAt gets the variables known at the
+	// first statement that starts at or after this line.
+	target int
+	envAt  map[string]string
+}
+
+// Check parses the Lua file and reports the use of fields which are not
+// provided by the ets libraries, objects and nodes and wrong calls of ets
+// functions. The types of variables are derived from assignments such as
+// local d = document.new(...) or local g = node.new("glyph"). A syntax
+// error is returned as an error.
+func Check(filename string) ([]CheckMessage, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := checkSource(string(src), filename, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.messages, nil
+}
+
+// checkSource checks the Lua source code. If target is not 0, the variables
+// known at the line target are available in c.envAt.
+func checkSource(src string, filename string, target int) (*checker, error) {
+	chunk, err := parse.Parse(strings.NewReader(src), filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		filename: filename,
+		lines:    strings.Split(src, "\n"),
+		target:   target,
+	}
+	env := map[string]string{
+		documentLibrary.name: documentLibrary.className,
+		nodeLibrary.name:     nodeLibrary.className,
+		jsonLibrary.name:     jsonLibrary.className,
+	}
+	c.stmts(chunk, env)
+	if c.envAt == nil {
+		c.envAt = env
+	}
+	sort.SliceStable(c.messages, func(i, j int) bool {
+		return c.messages[i].Line < c.messages[j].Line
+	})
+	return c, nil
+}
+
+// report adds a message. The column is the position of name in the line.
+func (c *checker) report(line int, name string, format string, a ...interface{}) {
+	cm := CheckMessage{
+		Filename: c.filename,
+		Line:     line,
+		Message:  fmt.Sprintf(format, a...),
+	}
+	if name != "" && line > 0 && line <= len(c.lines) {
+		if i := identifierIndex(c.lines[line-1], name); i >= 0 {
+			cm.Column = i + 1
+		}
+	}
+	c.messages = append(c.messages, cm)
+}
+
+// identifierIndex returns the byte index of the identifier name in the line
+// or -1.
+func identifierIndex(line string, name string) int {
+	for start := 0; ; {
+		i := strings.Index(line[start:], name)
+		if i < 0 {
+			return -1
+		}
+		i += start
+		end := i + len(name)
+		if (i == 0 || !isIdentifierByte(line[i-1])) && (end == len(line) || !isIdentifierByte(line[end])) {
+			return i
+		}
+		start = end
+	}
+}
+
+func isIdentifierByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+var (
+	apiOnce    sync.Once
+	apiClasses map[string]*Class
+)
+
+// apiClass returns the description of the library or the class with the
+// name or nil.
+func apiClass(name string) *Class {
+	apiOnce.Do(func() {
+		apiClasses = map[string]*Class{}
+		for _, cls := range API() {
+			cls := cls
+			apiClasses[cls.Name] = &cls
+		}
+	})
+	return apiClasses[name]
+}
+
+// members returns the functions and the fields of the kind (including the
+// parent classes) that can be read or, if write is true, set. Libraries have
+// no fields that can be set.
+func members(kind string, write bool) ([]Function, []Field) {
+	var functions []Function
+	var fields []Field
+	for cls := apiClass(kind); cls != nil; cls = apiClass(cls.Parent) {
+		if cls.Global != "" && write {
+			break
+		}
+		if !write {
+			functions = append(functions, cls.Functions...)
+		}
+		for _, f := range cls.Fields {
+			if write && f.Write || !write && f.Read {
+				fields = append(fields, f)
+			}
+		}
+		if cls.Parent == "" {
+			break
+		}
+	}
+	return functions, fields
+}
+
+// kindDescription returns the name of the kind for messages or the empty
+// string if the kind is not known.
+func kindDescription(kind string) string {
+	cls := apiClass(kind)
+	switch {
+	case cls == nil:
+		return ""
+	case cls.Global != "":
+		return "the " + cls.Global + " library"
+	case cls.NodeType != "":
+		return cls.NodeType + " nodes"
+	case cls.Name == luaDocTypeName:
+		return "a document"
+	}
+	return cls.Name + " objects"
+}
+
+// knownFields returns the fields that can be read (or set if write is true) for the
+// kind and the name of the kind for messages.
+func knownFields(kind string, write bool) ([]string, string) {
+	description := kindDescription(kind)
+	if description == "" {
+		return nil, ""
+	}
+	functions, fields := members(kind, write)
+	var names []string
+	for _, f := range functions {
+		names = append(names, f.Name)
+	}
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names, description
+}
+
+// function returns the function of the kind with the name or nil.
+func function(kind string, name string) *Function {
+	functions, _ := members(kind, false)
+	for i := range functions {
+		if functions[i].Name == name {
+			return &functions[i]
+		}
+	}
+	return nil
+}
+
+// qualifiedName returns the name of the function as it is used in the
+// reference, such as node.linebreak or doc.newpage.
+func qualifiedName(kind string, name string) string {
+	if cls := apiClass(kind); cls != nil && cls.Global != "" {
+		return cls.Global + "." + name
+	}
+	return kind + "." + name
+}
+
+// returnKind returns the kind of a value with the type from the binding
+// metadata. The first type of a union such as doc|false is used. The generic
+// node type is unknown as any node type is possible.
+func returnKind(typ string) string {
+	if i := strings.IndexAny(typ, "|?"); i >= 0 {
+		typ = typ[:i]
+	}
+	if typ == luaNodeTypeName || apiClass(typ) == nil {
+		return kindUnknown
+	}
+	return typ
+}
+
+// checkField reports if the key is not a field of kind.
+func (c *checker) checkField(line int, kind string, key string, write bool) {
+	names, description := knownFields(kind, write)
+	if description == "" {
+		return
+	}
+	for _, name := range names {
+		if name == key {
+			return
+		}
+	}
+	access := "read"
+	if write {
+		access = "set"
+	}
+	msg := fmt.Sprintf("unknown field %q in %s (%s)", key, description, access)
+	for _, name := range names {
+		if strings.EqualFold(name, key) {
+			msg += fmt.Sprintf(", did you mean %q?", name)
+			break
+		}
+	}
+	c.report(line, key, "%s", msg)
+}
+
+// checkCall reports calls of ets functions with a colon and calls with a
+// wrong number of arguments. kind is the kind of the library or the object.
+func (c *checker) checkCall(e *ast.FuncCallExpr, kind string, name string) {
+	fn := function(kind, name)
+	if fn == nil {
+		return
+	}
+	qname := qualifiedName(kind, name)
+	if e.Receiver != nil {
+		c.report(e.Line(), name, "%s must be called with a dot, not with a colon", qname)
+		return
+	}
+	required := 0
+	for i, arg := range fn.Args {
+		if !arg.Optional {
+			required = i + 1
+		}
+	}
+	n := len(e.Args)
+	if n > 0 {
+		switch e.Args[n-1].(type) {
+		case *ast.FuncCallExpr, *ast.Comma3Expr:
+			// the number of values is not known
+			if n-1 > len(fn.Args) {
+				c.report(e.Line(), name, "too many arguments for %s (%d expected, got %d)", qname, len(fn.Args), n)
+			}
+			return
+		}
+	}
+	switch {
+	case n < required:
+		c.report(e.Line(), name, "not enough arguments for %s (%d expected, got %d)", qname, required, n)
+	case n > len(fn.Args):
+		c.report(e.Line(), name, "too many arguments for %s (%d expected, got %d)", qname, len(fn.Args), n)
+	}
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	newenv := make(map[string]string, len(env))
+	for k, v := range env {
+		newenv[k] = v
+	}
+	return newenv
+}
+
+// assign sets the kind of the variable name.
+func assign(env map[string]string, name string, kind string) {
+	if kind == kindUnknown {
+		delete(env, name)
+	} else {
+		env[name] = kind
+	}
+}
+
+func (c *checker) stmts(stmts []ast.Stmt, env map[string]string) {
+	for _, stmt := range stmts {
+		if c.target > 0 && c.envAt == nil && stmt.Line() >= c.target {
+			c.envAt = copyEnv(env)
+		}
+		c.stmt(stmt, env)
+	}
+}
+
+func (c *checker) stmt(stmt ast.Stmt, env map[string]string) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		kinds := make([]string, len(s.Rhs))
+		for i, e := range s.Rhs {
+			kinds[i] = c.expr(e, env)
+		}
+		for i, lhs := range s.Lhs {
+			kind := kindUnknown
+			if i < len(kinds) {
+				kind = kinds[i]
+			}
+			switch v := lhs.(type) {
+			case *ast.IdentExpr:
+				assign(env, v.Value, kind)
+			case *ast.AttrGetExpr:
+				objkind := c.expr(v.Object, env)
+				if key, ok := v.Key.(*ast.StringExpr); ok {
+					// libraries can get new fields
+					if cls := apiClass(objkind); cls != nil && cls.Global == "" {
+						c.checkField(lhs.Line(), objkind, key.Value, true)
+					}
+				} else {
+					c.expr(v.Key, env)
+				}
+			default:
+				c.expr(lhs, env)
+			}
+		}
+	case *ast.LocalAssignStmt:
+		kinds := make([]string, len(s.Exprs))
+		for i, e := range s.Exprs {
+			kinds[i] = c.expr(e, env)
+		}
+		for i, name := range s.Names {
+			kind := kindUnknown
+			if i < len(kinds) {
+				kind = kinds[i]
+			}
+			assign(env, name, kind)
+		}
+	case *ast.FuncCallStmt:
+		c.expr(s.Expr, env)
+	case *ast.DoBlockStmt:
+		c.stmts(s.Stmts, env)
+	case *ast.WhileStmt:
+		c.expr(s.Condition, env)
+		c.stmts(s.Stmts, env)
+	case *ast.RepeatStmt:
+		c.stmts(s.Stmts, env)
+		c.expr(s.Condition, env)
+	case *ast.IfStmt:
+		c.expr(s.Condition, env)
+		c.stmts(s.Then, env)
+		c.stmts(s.Else, env)
+	case *ast.NumberForStmt:
+		c.expr(s.Init, env)
+		c.expr(s.Limit, env)
+		if s.Step != nil {
+			c.expr(s.Step, env)
+		}
+		delete(env, s.Name)
+		c.stmts(s.Stmts, env)
+	case *ast.GenericForStmt:
+		for _, e := range s.Exprs {
+			c.expr(e, env)
+		}
+		for _, name := range s.Names {
+			delete(env, name)
+		}
+		c.stmts(s.Stmts, env)
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			if id, ok := s.Name.Func.(*ast.IdentExpr); ok {
+				delete(env, id.Value)
+			}
+		}
+		c.expr(s.Func, env)
+	case *ast.ReturnStmt:
+		for _, e := range s.Exprs {
+			c.expr(e, env)
+		}
+	}
+}
+
+// expr checks the expression and returns its kind.
+func (c *checker) expr(expr ast.Expr, env map[string]string) string {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		return env[e.Value]
+	case *ast.AttrGetExpr:
+		objkind := c.expr(e.Object, env)
+		if key, ok := e.Key.(*ast.StringExpr); ok {
+			c.checkField(e.Line(), objkind, key.Value, false)
+		} else {
+			c.expr(e.Key, env)
+		}
+	case *ast.FuncCallExpr:
+		var kind string
+		if e.Receiver != nil {
+			objkind := c.expr(e.Receiver, env)
+			c.checkField(e.Line(), objkind, e.Method, false)
+			c.checkCall(e, objkind, e.Method)
+		} else {
+			kind = c.callKind(e, env)
+		}
+		for _, arg := range e.Args {
+			c.expr(arg, env)
+		}
+		return kind
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			if field.Key != nil {
+				c.expr(field.Key, env)
+			}
+			c.expr(field.Value, env)
+		}
+	case *ast.LogicalOpExpr:
+		c.expr(e.Lhs, env)
+		c.expr(e.Rhs, env)
+	case *ast.RelationalOpExpr:
+		c.expr(e.Lhs, env)
+		c.expr(e.Rhs, env)
+	case *ast.StringConcatOpExpr:
+		c.expr(e.Lhs, env)
+		c.expr(e.Rhs, env)
+	case *ast.ArithmeticOpExpr:
+		c.expr(e.Lhs, env)
+		c.expr(e.Rhs, env)
+	case *ast.UnaryMinusOpExpr:
+		c.expr(e.Expr, env)
+	case *ast.UnaryNotOpExpr:
+		c.expr(e.Expr, env)
+	case *ast.UnaryLenOpExpr:
+		c.expr(e.Expr, env)
+	case *ast.FunctionExpr:
+		fenv := copyEnv(env)
+		for _, name := range e.ParList.Names {
+			delete(fenv, name)
+		}
+		c.stmts(e.Stmts, fenv)
+	}
+	return kindUnknown
+}
+
+// callKind checks the function call and returns the kind of its (first)
+// return value.
+func (c *checker) callKind(e *ast.FuncCallExpr, env map[string]string) string {
+	attr, ok := e.Func.(*ast.AttrGetExpr)
+	if !ok {
+		c.expr(e.Func, env)
+		return kindUnknown
+	}
+	objkind := c.expr(attr.Object, env)
+	key, ok := attr.Key.(*ast.StringExpr)
+	if !ok {
+		c.expr(attr.Key, env)
+		return kindUnknown
+	}
+	c.checkField(attr.Line(), objkind, key.Value, false)
+	c.checkCall(e, objkind, key.Value)
+	if objkind == nodeLibrary.className && key.Value == "new" && len(e.Args) > 0 {
+		if typename, ok := e.Args[0].(*ast.StringExpr); ok {
+			nt, ok := nodeTypes[typename.Value]
+			if !ok {
+				c.report(e.Line(), typename.Value, "unknown node type %q in node.new", typename.Value)
+				return kindUnknown
+			}
+			return nt.class.name
+		}
+	}
+	if fn := function(objkind, key.Value); fn != nil && len(fn.Returns) > 0 {
+		return returnKind(fn.Returns[0].Type)
+	}
+	return kindUnknown
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "no problems",
+			src: `local d = document.new("out.pdf")
+local g = node.new("glyph")
+g.width = 1
+d.newpage()`,
+		},
+		{
+			name: "misspelled field",
+			src: `local d = document.new("out.pdf")
+local img = d.createImage("a.pdf")
+local g = node.new("glyph")
+g.heigth = 1`,
+			want: []string{
+				`test.lua:2:15: unknown field "createImage" in a document (read), did you mean "createimage"?`,
+				`test.lua:4:3: unknown field "heigth" in glyph nodes (set)`,
+			},
+		},
+		{
+			name: "misspelled library function",
+			src:  `local x = document.spp("1pt")`,
+			want: []string{`test.lua:1:20: unknown field "spp" in the document library (read)`},
+		},
+		{
+			name: "unknown node type",
+			src:  `local x = node.new("glyf")`,
+			want: []string{`test.lua:1:21: unknown node type "glyf" in node.new`},
+		},
+		{
+			name: "colon call",
+			src: `local d = document.new("out.pdf")
+d:newpage()`,
+			want: []string{`test.lua:2:3: doc.newpage must be called with a dot, not with a colon`},
+		},
+		{
+			name: "too many arguments",
+			src: `local d = document.new("out.pdf")
+d.newpage(1, 2)`,
+			want: []string{`test.lua:2:3: too many arguments for doc.newpage (0 expected, got 2)`},
+		},
+		{
+			name: "not enough arguments",
+			src:  `local vl = node.linebreak()`,
+			want: []string{`test.lua:1:17: not enough arguments for node.linebreak (2 expected, got 0)`},
+		},
+		{
+			name: "variable arguments",
+			src:  `local vl = node.linebreak(unpack(args))`,
+		},
+	} {
+		fn := filepath.Join(dir, "test.lua")
+		writeTestFile(t, fn, tc.src)
+		messages, err := Check(fn)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, msg := range messages {
+			got = append(got, fmt.Sprintf("%s:%d:%d: %s", filepath.Base(msg.Filename), msg.Line, msg.Column, msg.Message))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckSyntaxError(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.lua")
+	writeTestFile(t, fn, "local d = document.new(\"out.pdf\")\nlocal x = \n")
+	if _, err := Check(fn); err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("got error %v, want a syntax error", err)
+	}
+}
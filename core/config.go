@@ -0,0 +1,225 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ConfigFilename is the name of the project configuration file which is
+// searched in the directory of the Lua file.
+const ConfigFilename = "ets.toml"
+
+// Config is the project configuration from the file ets.toml. Relative file
+// names are relative to the directory of the configuration file.
+type Config struct {
+	// Filename is the absolute name of the configuration file.
+	Filename string `toml:"-"`
+	// Output replaces the file name in document.new(), see
+	// Session.OutputFile.
+	Output string `toml:"output"`
+	// SearchPaths are directories for fonts, images, pattern files and Lua
+	// modules.
+	SearchPaths []string `toml:"searchpaths"`
+	// LuaPath are entries for the Lua search path, see Session.LuaPath.
+	LuaPath []string `toml:"luapath"`
+	// Language is the language tag of the bundled hyphenation patterns or the
+	// pattern file for the default language of new documents.
+	Language string `toml:"language"`
+	// LogLevel is the minimum level of log messages.
+	LogLevel string `toml:"loglevel"`
+	// Variables are available in the Lua table vars.
+	Variables map[string]string `toml:"variables"`
+	// InitFiles are run after the startup files.
+	InitFiles []string `toml:"init"`
+	// Data contains all settings of the configuration file, including the
+	// settings not known to ets.
+	Data map[string]interface{} `toml:"-"`
+}
+
+// FindConfig reads the configuration file in the directory of the Lua file.
+// It returns nil if there is no configuration file.
+func FindConfig(luafile string) (*Config, error) {
+	fn, err := filepath.Abs(filepath.Join(filepath.Dir(luafile), ConfigFilename))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(fn); err != nil {
+		return nil, nil
+	}
+	return LoadConfig(fn)
+}
+
+// FindConfigFS reads the configuration file in the directory of the Lua file
+// from the file system fsys (see Session.FS). It returns nil if there is no
+// configuration file. The file names in the configuration are names in fsys.
+func FindConfigFS(fsys fs.FS, luafile string) (*Config, error) {
+	fn := path.Join(path.Dir(fsName(luafile)), ConfigFilename)
+	data, err := fs.ReadFile(fsys, fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data, fn, func(fn string) bool {
+		_, err := fs.Stat(fsys, fsName(fn))
+		return err == nil
+	})
+}
+
+// LoadConfig reads the configuration file.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if filename, err = filepath.Abs(filename); err != nil {
+		return nil, err
+	}
+	return parseConfig(data, filename, func(fn string) bool {
+		_, err := os.Stat(fn)
+		return err == nil
+	})
+}
+
+// parseConfig decodes the configuration file. exists reports whether a file
+// exists, it is used to tell a pattern file name from a language name.
+func parseConfig(data []byte, filename string, exists func(string) bool) (*Config, error) {
+	cfg := &Config{Filename: filename}
+	if _, err := toml.Decode(string(data), cfg); err != nil {
+		return nil, err
+	}
+	if _, err := toml.Decode(string(data), &cfg.Data); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(cfg.Filename)
+	abs := func(fn string) string {
+		if fn == "" || fn == "-" || filepath.IsAbs(fn) {
+			return fn
+		}
+		return filepath.Join(dir, fn)
+	}
+	cfg.Output = abs(cfg.Output)
+	for i, path := range cfg.SearchPaths {
+		cfg.SearchPaths[i] = abs(path)
+	}
+	for i, entry := range cfg.LuaPath {
+		cfg.LuaPath[i] = abs(entry)
+	}
+	for i, fn := range cfg.InitFiles {
+		cfg.InitFiles[i] = abs(fn)
+	}
+	if exists(abs(cfg.Language)) {
+		cfg.Language = abs(cfg.Language)
+	}
+	return cfg, nil
+}
+
+// ApplyConfig sets the settings of the session from the configuration that
+// are not set yet, so settings from the command line take precedence. Init
+// files from the configuration run before the init files of the session. In
+// the sandbox the search paths, the Lua path and the init files of the
+// configuration are ignored, as the configuration file comes with the
+// script. Set Sandbox before calling ApplyConfig.
+func (s *Session) ApplyConfig(cfg *Config) {
+	s.Config = cfg
+	if s.OutputFile == "" {
+		s.OutputFile = cfg.Output
+	}
+	if s.DefaultLanguage == "" {
+		s.DefaultLanguage = cfg.Language
+	}
+	if s.LogLevel == "" {
+		s.LogLevel = cfg.LogLevel
+	}
+	if !s.Sandbox {
+		s.SearchPaths = append(append([]string{}, s.SearchPaths...), cfg.SearchPaths...)
+		s.LuaPath = append(append([]string{}, s.LuaPath...), cfg.LuaPath...)
+		s.InitFiles = append(append([]string{}, cfg.InitFiles...), s.InitFiles...)
+	}
+	variables := map[string]string{}
+	for k, v := range cfg.Variables {
+		variables[k] = v
+	}
+	for k, v := range s.Variables {
+		variables[k] = v
+	}
+	s.Variables = variables
+}
+
+// registerConfig sets the table document.config with the settings of the
+// configuration file and the effective settings of the session.
+func (s *Session) registerConfig(l *lua.LState) {
+	tbl := l.NewTable()
+	if s.Config != nil {
+		tbl = goToLua(l, s.Config.Data).(*lua.LTable)
+		tbl.RawSetString("filename", lua.LString(s.Config.Filename))
+	}
+	tbl.RawSetString("output", lua.LString(s.OutputFile))
+	tbl.RawSetString("language", lua.LString(s.DefaultLanguage))
+	tbl.RawSetString("loglevel", lua.LString(s.LogLevel))
+	tbl.RawSetString("searchpaths", goToLua(l, s.SearchPaths))
+	tbl.RawSetString("luapath", goToLua(l, s.LuaPath))
+	tbl.RawSetString("init", goToLua(l, s.InitFiles))
+	variables := l.NewTable()
+	for k, v := range s.Variables {
+		variables.RawSetString(k, lua.LString(v))
+	}
+	tbl.RawSetString("variables", variables)
+	l.SetField(l.GetGlobal("document"), "config", tbl)
+}
+
+// goToLua converts values decoded from TOML to Lua values.
+func goToLua(l *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case string:
+		return lua.LString(t)
+	case bool:
+		return lua.LBool(t)
+	case int64:
+		return lua.LNumber(t)
+	case float64:
+		return lua.LNumber(t)
+	case []string:
+		tbl := l.NewTable()
+		for _, s := range t {
+			tbl.Append(lua.LString(s))
+		}
+		return tbl
+	case []interface{}:
+		tbl := l.NewTable()
+		for _, item := range t {
+			tbl.Append(goToLua(l, item))
+		}
+		return tbl
+	case []map[string]interface{}:
+		tbl := l.NewTable()
+		for _, item := range t {
+			tbl.Append(goToLua(l, item))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := l.NewTable()
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tbl.RawSetString(k, goToLua(l, t[k]))
+		}
+		return tbl
+	case nil:
+		return l.NewTable()
+	}
+	// dates and times
+	return lua.LString(fmt.Sprint(v))
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func writeTestFile(t *testing.T, fn string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "patterns", "my.pat.txt"), "")
+	for _, tc := range []struct {
+		name    string
+		toml    string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "relative file names",
+			toml: `output = "build/{script}.pdf"
+searchpaths = ["fonts", "/usr/share/fonts"]
+luapath = ["lib/?.lua"]
+init = ["setup.lua"]
+loglevel = "info"
+[variables]
+customer = "acme"`,
+			want: Config{
+				Output:      filepath.Join(dir, "build/{script}.pdf"),
+				SearchPaths: []string{filepath.Join(dir, "fonts"), "/usr/share/fonts"},
+				LuaPath:     []string{filepath.Join(dir, "lib/?.lua")},
+				InitFiles:   []string{filepath.Join(dir, "setup.lua")},
+				LogLevel:    "info",
+				Variables:   map[string]string{"customer": "acme"},
+			},
+		},
+		{name: "language tag", toml: `language = "de"`, want: Config{Language: "de"}},
+		{name: "pattern file", toml: `language = "patterns/my.pat.txt"`, want: Config{Language: filepath.Join(dir, "patterns/my.pat.txt")}},
+		{name: "standard output", toml: `output = "-"`, want: Config{Output: "-"}},
+		{name: "syntax error", toml: `output = `, wantErr: true},
+	} {
+		writeTestFile(t, filepath.Join(dir, ConfigFilename), tc.toml)
+		cfg, err := FindConfig(filepath.Join(dir, "script.lua"))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error %v, want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.Filename != filepath.Join(dir, ConfigFilename) {
+			t.Errorf("%s: Filename = %s", tc.name, cfg.Filename)
+		}
+		cfg.Filename = ""
+		cfg.Data = nil
+		if !reflect.DeepEqual(*cfg, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *cfg, tc.want)
+		}
+	}
+	cfg, err := FindConfig(filepath.Join(t.TempDir(), "script.lua"))
+	if cfg != nil || err != nil {
+		t.Errorf("without configuration file: got %v, %v", cfg, err)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	cfg := &Config{
+		Output:      "cfg.pdf",
+		SearchPaths: []string{"/cfg/fonts"},
+		LuaPath:     []string{"/cfg/lib"},
+		InitFiles:   []string{"/cfg/setup.lua"},
+		Language:    "de",
+		LogLevel:    "warn",
+		Variables:   map[string]string{"a": "cfg", "b": "cfg"},
+	}
+	for _, tc := range []struct {
+		name    string
+		session Session
+		want    Session
+	}{
+		{
+			name:    "defaults from the configuration",
+			session: Session{},
+			want: Session{
+				OutputFile:      "cfg.pdf",
+				SearchPaths:     []string{"/cfg/fonts"},
+				LuaPath:         []string{"/cfg/lib"},
+				InitFiles:       []string{"/cfg/setup.lua"},
+				DefaultLanguage: "de",
+				LogLevel:        "warn",
+				Variables:       map[string]string{"a": "cfg", "b": "cfg"},
+			},
+		},
+		{
+			name: "command line first",
+			session: Session{
+				OutputFile:      "cli.pdf",
+				SearchPaths:     []string{"/cli/fonts"},
+				LuaPath:         []string{"/cli/lib"},
+				InitFiles:       []string{"/cli/init.lua"},
+				DefaultLanguage: "fr",
+				LogLevel:        "debug",
+				Variables:       map[string]string{"a": "cli"},
+			},
+			want: Session{
+				OutputFile:      "cli.pdf",
+				SearchPaths:     []string{"/cli/fonts", "/cfg/fonts"},
+				LuaPath:         []string{"/cli/lib", "/cfg/lib"},
+				InitFiles:       []string{"/cfg/setup.lua", "/cli/init.lua"},
+				DefaultLanguage: "fr",
+				LogLevel:        "debug",
+				Variables:       map[string]string{"a": "cli", "b": "cfg"},
+			},
+		},
+		{
+			name:    "sandbox",
+			session: Session{Sandbox: true},
+			want: Session{
+				Sandbox:         true,
+				SearchPaths:     nil,
+				OutputFile:      "cfg.pdf",
+				DefaultLanguage: "de",
+				LogLevel:        "warn",
+				Variables:       map[string]string{"a": "cfg", "b": "cfg"},
+			},
+		},
+	} {
+		s := tc.session
+		s.ApplyConfig(cfg)
+		if s.Config != cfg {
+			t.Errorf("%s: Config not set", tc.name)
+		}
+		s.Config = nil
+		if !reflect.DeepEqual(s, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, s, tc.want)
+		}
+	}
+}
+
+func TestSandboxSearchPath(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(outside, "data.json"), `{"secret": true}`)
+	writeTestFile(t, filepath.Join(dir, ConfigFilename), `searchpaths = ["`+filepath.ToSlash(outside)+`"]`)
+	cfg, err := FindConfig(filepath.Join(dir, "script.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		setup  func(s *Session)
+		script string
+	}{
+		{"searchpath from the configuration", func(s *Session) { s.ApplyConfig(cfg) }, `assert(json.decodefile("data.json"))`},
+		{"absolute file in a search path", func(s *Session) { s.SearchPaths = []string{outside} }, `assert(json.decodefile("` + filepath.ToSlash(filepath.Join(outside, "data.json")) + `"))`},
+		{"document.addsearchpath", func(s *Session) {}, `document.addsearchpath("` + filepath.ToSlash(outside) + `") assert(json.decodefile("data.json"))`},
+	} {
+		s := &Session{
+			Luafile: filepath.Join(dir, "script.lua"),
+			Source:  tc.script,
+			Dir:     dir,
+			Sandbox: true,
+			Logger:  zap.NewNop().Sugar(),
+		}
+		tc.setup(s)
+		err := s.Run()
+		if err == nil || !strings.Contains(err.Error(), "not allowed in the sandbox") && !strings.Contains(err.Error(), "not found") {
+			t.Errorf("%s: got error %v, want access denied", tc.name, err)
+		}
+		// with --allow-dir the file can be read
+		s.AllowedDirs = []string{outside}
+		if err := s.Run(); err != nil && tc.name != "searchpath from the configuration" {
+			t.Errorf("%s with allowed directory: %v", tc.name, err)
+		}
+	}
+}
@@ -1,54 +1,120 @@
 package core
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
-	"github.com/speedata/boxesandglue/backend/bag"
 	lua "github.com/yuin/gopher-lua"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// Runs the Lua file if it exists. If the exename is "foo", it looks for a Lua file called "foo.lua"
-func runDefaultLua(l *lua.LState, exename string) error {
-	var extension = filepath.Ext(exename)
-	var name = exename[0:len(exename)-len(extension)] + ".lua"
-	var err error
-	if _, err = os.Stat(name); err != nil {
-		return nil
-	}
-	return l.DoFile(name)
+// startupFiles returns the startup files in the order they are run. If the
+// exename is "foo", ets looks for a Lua file called "foo.lua" in the directory
+// of the executable, in the directory "foo" in the user configuration
+// directory and in the project directory (Dir or the current working
+// directory). The files in InitFiles are always run, after the others. With a
+// file system FS only the InitFiles are run.
+func (s *Session) startupFiles() []string {
+	var files []string
+	seen := map[string]bool{}
+	add := func(fn string) {
+		abs, err := filepath.Abs(fn)
+		if err != nil || seen[abs] {
+			return
+		}
+		seen[abs] = true
+		files = append(files, fn)
+	}
+	if s.Exename != "" && s.FS == nil {
+		base := s.Exename[0 : len(s.Exename)-len(filepath.Ext(s.Exename))]
+		name := base + ".lua"
+		var dirs []string
+		if exe, err := os.Executable(); err == nil {
+			if exe, err = filepath.EvalSymlinks(exe); err == nil {
+				dirs = append(dirs, filepath.Dir(exe))
+			}
+		}
+		if dir, err := os.UserConfigDir(); err == nil {
+			dirs = append(dirs, filepath.Join(dir, base))
+		}
+		dirs = append(dirs, s.resolve("."))
+		for _, dir := range dirs {
+			fn := filepath.Join(dir, name)
+			if _, err := os.Stat(fn); err == nil {
+				add(fn)
+			}
+		}
+	}
+	for _, fn := range s.InitFiles {
+		add(fn)
+	}
+	return files
 }
 
-func newZapLogger() *zap.SugaredLogger {
-	logger, _ := zap.Config{
-		Level:       zap.NewAtomicLevelAt(zapcore.DebugLevel),
-		Encoding:    "console",
-		OutputPaths: []string{"stdout"},
-		EncoderConfig: zapcore.EncoderConfig{
-			EncodeLevel: zapcore.LowercaseColorLevelEncoder,
-			LevelKey:    "level",
-			MessageKey:  "message",
-		},
-	}.Build()
-	return logger.Sugar()
+// runStartupFiles runs all startup files.
+func (s *Session) runStartupFiles(l *lua.LState) error {
+	for _, fn := range s.startupFiles() {
+		s.logger.Infof("Running startup file %s", fn)
+		s.addDependency(fn)
+		if err := s.doLuaFile(l, fn); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Dothings opens the Lua file and executes it
-func Dothings(luafile string, exename string) error {
-	l := lua.NewState()
-	bag.Logger = newZapLogger()
-	registerDocumentType(l)
-	registerNodeType(l)
-
-	if err := runDefaultLua(l, exename); err != nil {
-		return err
+// NewLogger creates a logger for the given level (debug, info, warn or
+// error), output file ("" or "stdout" for the standard output) and format
+// (console or json). The returned function flushes the logger and closes the
+// log file.
+func NewLogger(level string, logfile string, format string) (*zap.SugaredLogger, func(), error) {
+	lvl := zapcore.DebugLevel
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, nil, fmt.Errorf("unknown log level %q", level)
+		}
 	}
-
-	if err := l.DoFile(luafile); err != nil {
-		return err
+	if logfile == "" {
+		logfile = "stdout"
 	}
+	encoderConfig := zapcore.EncoderConfig{
+		EncodeLevel: zapcore.LowercaseLevelEncoder,
+		LevelKey:    "level",
+		MessageKey:  "message",
+	}
+	var encoder zapcore.Encoder
+	switch format {
+	case "", "console":
+		if logfile == "stdout" || logfile == "stderr" {
+			encoderConfig.EncodeLevel = zapcore.LowercaseColorLevelEncoder
+		}
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	case "json":
+		encoderConfig.TimeKey = "time"
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q, must be console or json", format)
+	}
+	sink, closeSink, err := zap.Open(logfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger := zap.New(zapcore.NewCore(encoder, sink, lvl))
+	return logger.Sugar(), func() {
+		logger.Sync()
+		closeSink()
+	}, nil
+}
 
-	return nil
+// Dothings opens the Lua file and executes it
+func Dothings(luafile string, exename string) error {
+	s := &Session{
+		Luafile: luafile,
+		Exename: exename,
+	}
+	defer s.Close()
+	return s.Run()
 }
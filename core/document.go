@@ -1,18 +1,36 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 
 	"github.com/speedata/boxesandglue/backend/bag"
 	"github.com/speedata/boxesandglue/backend/lang"
 	"github.com/speedata/boxesandglue/document"
+	"github.com/speedata/ets/hyphenationpatterns"
 	lua "github.com/yuin/gopher-lua"
 )
 
 type doc struct {
 	d *document.Document
-	w *os.File
+	w *limitWriter
+	// buf has the PDF file if the document is rendered into memory
+	buf *bytes.Buffer
+	// tofile is true if the PDF is written to the file d.Filename
+	tofile bool
+}
+
+// nopWriteCloser is a writer with a Close method that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
 }
 
 type bagLang struct {
@@ -21,36 +39,211 @@ type bagLang struct {
 
 const (
 	luaDocumentTypeName = "document"
+	luaDocTypeName      = "doc"
 )
 
+// fontSourceArg is the table argument of doc.loadFace() and
+// fontfamily.addmember().
+var fontSourceArg = Value{Name: "fontsource", Type: "table", Description: "A table with the fields `name` (the name of the font) and `source` (the font file)."}
+
+// logArgs are the arguments of the log functions.
+var logArgs = []Value{
+	{Name: "message", Type: "string", Description: "The log message."},
+	{Name: "fields", Type: "table", Optional: true, Description: "Key/value pairs that are added to the log entry as structured fields."},
+}
+
+// errorReturn is the error message returned after false.
+var errorReturn = Value{Name: "err", Type: "string", Optional: true, Description: "The error message."}
+
+// documentLibrary is the global table document.
+var documentLibrary = &luaLibrary{
+	name:        luaDocumentTypeName,
+	className:   "documentlib",
+	description: "The document library has all general information about a document / a PDF file.",
+	functions: []*luaFunction{
+		{Function{
+			Name:        "addsearchpath",
+			Args:        []Value{{Name: "dir", Type: "string", Description: "The directory, relative to the directory of the Lua file."}},
+			Returns:     []Value{{Name: "ok", Type: "true|false", Description: "True if the directory was added."}, errorReturn},
+			Description: "Search fonts, images, pattern files and Lua modules in the directory. Files given by name only are also searched in the subdirectories.",
+		}, documentAddSearchPath},
+		{Function{Name: "debug", Args: logArgs, Description: "Log with debug level."}, documentDebug},
+		{Function{Name: "error", Args: logArgs, Description: "Log with error level."}, documentError},
+		{Function{Name: "info", Args: logArgs, Description: "Log with info level."}, documentInfo},
+		{Function{
+			Name:        "new",
+			Args:        []Value{{Name: "filename", Type: "string", Optional: true, Description: "The name of the PDF file."}},
+			Returns:     []Value{{Name: "d", Type: "doc|false", Description: "The new document."}, errorReturn},
+			Description: "Create a new PDF file. Without a file name or with `-` the PDF is rendered into memory (or written to the standard output with `-o -`).",
+		}, newDocument},
+		{Function{
+			Name:        "sp",
+			Args:        []Value{{Name: "dimension", Type: "string", Description: "A length with unit such as `12pt` or `1cm`."}},
+			Returns:     []Value{{Name: "sp", Type: "number|false", Description: "The length in scaled points."}, errorReturn},
+			Description: "Convert the string to scaled points (1/65536 of a DTP point).",
+		}, documentSP},
+		{Function{Name: "warn", Args: logArgs, Description: "Log with warn level."}, documentWarn},
+	},
+	fields: []Value{
+		{Name: "config", Type: "table", Description: "The settings of the project configuration file and the effective settings of the run."},
+	},
+}
+
+// docClass describes the document objects created by document.new().
+var docClass = &luaClass{
+	name:        luaDocTypeName,
+	description: "A document object represents a PDF file. It is created with `document.new()`.",
+	methods: []*luaMethod{
+		{Function{
+			Name:        "loadFace",
+			Args:        []Value{fontSourceArg},
+			Returns:     []Value{{Name: "face", Type: "face|false", Description: "The font face."}, errorReturn},
+			Description: "Load a font file.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentLoadFace(d.d) })},
+		{Function{
+			Name: "createFont",
+			Args: []Value{
+				{Name: "face", Type: "face", Description: "The font face from `doc.loadFace()`."},
+				{Name: "size", Type: "number", Description: "The font size in scaled points."},
+			},
+			Returns:     []Value{{Name: "font", Type: "font", Description: "The font instance."}},
+			Description: "Get a font instance in the given size.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentCreateFont(d.d) })},
+		{Function{
+			Name:        "createimage",
+			Args:        []Value{{Name: "imagefile", Type: "imagefile", Description: "The image file from `doc.loadimagefile()`."}},
+			Returns:     []Value{{Name: "image", Type: "image", Description: "The image instance."}},
+			Description: "Create an image instance of the given image file.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentCreateImage(d.d) })},
+		{Function{
+			Name:        "currentpage",
+			Returns:     []Value{{Name: "page", Type: "page", Description: "The current page."}},
+			Description: "Get the current page object.",
+		}, docMethod(documentCurrentPage)},
+		{Function{
+			Name:        "finish",
+			Returns:     []Value{{Name: "result", Type: "true|string|false", Description: "`true` or the PDF file if the document is rendered into memory."}, errorReturn},
+			Description: "Close the PDF file. Returns the PDF file as a string if the document is rendered into memory.",
+		}, docMethod(documentFinish)},
+		{Function{
+			Name:        "hyphenate",
+			Args:        []Value{{Name: "nodelist", Type: "node", Description: "The head of the node list."}},
+			Description: "Insert disc nodes into the node list.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentHyphenate(d.d) })},
+		{Function{
+			Name:        "loadimagefile",
+			Args:        []Value{{Name: "filename", Type: "string", Description: "The name of the image file."}},
+			Returns:     []Value{{Name: "imagefile", Type: "imagefile|false", Description: "The image file."}, errorReturn},
+			Description: "Load an image file. The imagefile object represents a physical image.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentLoadImageFile(d.d) })},
+		{Function{
+			Name:        "loadpattern",
+			Args:        []Value{{Name: "language", Type: "string", Description: "A language tag such as `de` or `en-GB` for the bundled patterns or the name of a pattern file."}},
+			Returns:     []Value{{Name: "lang", Type: "lang|false", Description: "The language."}, errorReturn},
+			Description: "Load the hyphenation patterns for a language. The language object represents the patterns.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentLoadPatternFile(d.d) })},
+		{Function{
+			Name:        "mknodes",
+			Args:        []Value{{Name: "element", Type: "table", Description: "Strings and nested elements, optionally with a `settings` table (`fontfamily`, `color`, `weight`)."}},
+			Returns:     []Value{{Name: "hlist", Type: "hlistnode", Description: "The horizontal list."}, {Name: "tail", Type: "node", Description: "The last node of the list."}},
+			Description: "Create a node list from the typesetting element.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentMknodes(d.d) })},
+		{Function{
+			Name:        "newpage",
+			Returns:     []Value{{Name: "page", Type: "page", Description: "The new page."}},
+			Description: "Start an empty page.",
+		}, docMethod(documentNewPage)},
+		{Function{
+			Name:        "newfontfamily",
+			Args:        []Value{{Name: "name", Type: "string", Description: "The name of the font family."}},
+			Returns:     []Value{{Name: "fontfamily", Type: "fontfamily", Description: "The new font family."}},
+			Description: "Create a font family.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentNewFontfamily(d.d) })},
+		{Function{
+			Name: "outputat",
+			Args: []Value{
+				{Name: "x", Type: "number", Description: "The horizontal position in scaled points."},
+				{Name: "y", Type: "number", Description: "The vertical position in scaled points."},
+				{Name: "vlist", Type: "vlistnode", Description: "The vertical list."},
+			},
+			Description: "Place the vertical list on the current page.",
+		}, docMethod(func(d *doc) lua.LGFunction { return documentOutputAt(d.d) })},
+	},
+	fields: []*luaField{
+		{
+			Value: Value{Name: "defaultlanguage", Type: "lang", Description: "The default language of the document."},
+			index: func(l *lua.LState) int {
+				if lang := checkDocument(l, 1).d.DefaultLanguage; lang != nil {
+					l.Push(langClass.newUserData(l, lang))
+					return 1
+				}
+				return 0
+			},
+			newindex: func(l *lua.LState) int {
+				checkDocument(l, 1).d.SetDefaultLanguage(checkPatternFile(l, 3))
+				return 0
+			},
+		},
+	},
+}
+
+// docMethod turns a function that returns the method for a document into the
+// function of a luaMethod.
+func docMethod(fn func(d *doc) lua.LGFunction) func(l *lua.LState) lua.LGFunction {
+	return func(l *lua.LState) lua.LGFunction {
+		return fn(checkDocument(l, 1))
+	}
+}
+
 // Registers my document type to given l.
 func registerDocumentType(l *lua.LState) {
-	mt := l.NewTypeMetatable(luaDocumentTypeName)
-	l.SetGlobal("document", mt)
-	l.SetField(mt, "info", l.NewFunction(documentInfo))
-	l.SetField(mt, "new", l.NewFunction(newDocument))
-	l.SetField(mt, "sp", l.NewFunction(documentSP))
-	l.SetField(mt, "__index", l.NewFunction(indexDoc))
-	l.SetField(mt, "__newindex", l.NewFunction(newindexDoc))
+	documentLibrary.register(l)
 }
 
 // Constructor
+// newDocument creates a document. Without a file name or with the file name
+// "-" the PDF is written to the output writer of the session or into memory.
 func newDocument(l *lua.LState) int {
+	s := sessionFromState(l)
 	doc := &doc{}
-	filename := l.CheckString(1)
-	var w *os.File
-	var err error
-	w, err = os.Create(filename)
-	if err != nil {
-		return lerr(l, err.Error())
+	filename := l.OptString(1, "-")
+	if s.OutputFile != "" {
+		filename = s.outputFilename(filename)
+	}
+	var w io.WriteCloser
+	switch {
+	case filename != "-":
+		filename = s.resolve(filename)
+		if err := s.checkFile(filename); err != nil {
+			return etsError(l, ErrorIO, "document.new", err)
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			return etsError(l, ErrorIO, "document.new", err)
+		}
+		w = f
+		doc.tofile = true
+	case s.Output != nil:
+		if s.outputUsed {
+			return etsError(l, ErrorIO, "document.new", errors.New("only one document can be written to the output"))
+		}
+		s.outputUsed = true
+		w = nopWriteCloser{s.Output}
+	default:
+		doc.buf = &bytes.Buffer{}
+		w = nopWriteCloser{doc.buf}
 	}
-	doc.w = w
-	doc.d = document.NewDocument(w)
+	doc.w = &limitWriter{w: w, max: s.MaxOutputSize}
+	doc.d = document.NewDocument(doc.w)
 	doc.d.Filename = filename
-	ud := l.NewUserData()
-	ud.Value = doc
-	l.SetMetatable(ud, l.GetTypeMetatable(luaDocumentTypeName))
-	l.Push(ud)
+	if s.DefaultLanguage != "" {
+		lang, err := s.loadPattern(doc.d, s.DefaultLanguage)
+		if err != nil {
+			return etsError(l, ErrorIO, "document.new", err)
+		}
+		doc.d.SetDefaultLanguage(lang)
+	}
+	l.Push(docClass.newUserData(l, doc))
 	return 1
 }
 
@@ -64,69 +257,52 @@ func documentSP(l *lua.LState) int {
 	return 1
 }
 
+// logFields converts the optional table at argpos into key value pairs for
+// the structured logger.
+func logFields(l *lua.LState, argpos int) []interface{} {
+	tbl := l.OptTable(argpos, nil)
+	if tbl == nil {
+		return nil
+	}
+	var fields []interface{}
+	tbl.ForEach(func(k, v lua.LValue) {
+		var value interface{}
+		switch t := v.(type) {
+		case lua.LBool:
+			value = bool(t)
+		case lua.LNumber:
+			if f := float64(t); f == float64(int64(f)) {
+				value = int64(f)
+			} else {
+				value = f
+			}
+		case lua.LString:
+			value = string(t)
+		default:
+			value = v.String()
+		}
+		fields = append(fields, k.String(), value)
+	})
+	return fields
+}
+
+func documentDebug(l *lua.LState) int {
+	sessionFromState(l).logger.Debugw(l.CheckString(1), logFields(l, 2)...)
+	return 0
+}
+
 func documentInfo(l *lua.LState) int {
-	str := l.CheckString(1)
-	bag.Logger.Info(str)
+	sessionFromState(l).logger.Infow(l.CheckString(1), logFields(l, 2)...)
 	return 0
 }
 
-func indexDoc(l *lua.LState) int {
-	doc := checkDocument(l, 1)
-	switch arg := l.CheckString(2); arg {
-	case "loadFace":
-		l.Push(l.NewFunction(documentLoadFace(doc.d)))
-		return 1
-	case "createFont":
-		l.Push(l.NewFunction(documentCreateFont(doc.d)))
-		return 1
-	case "createimage":
-		l.Push(l.NewFunction(documentCreateImage(doc.d)))
-		return 1
-	case "currentpage":
-		l.Push(l.NewFunction(documentCurrentPage(doc.d)))
-		return 1
-	case "finish":
-		l.Push(l.NewFunction(documentFinish(doc)))
-		return 1
-	case "hyphenate":
-		l.Push(l.NewFunction(documentHyphenate(doc.d)))
-		return 1
-	case "loadimagefile":
-		l.Push(l.NewFunction(documentLoadImageFile(doc.d)))
-		return 1
-	case "loadpattern":
-		l.Push(l.NewFunction(documentLoadPatternFile(doc.d)))
-		return 1
-	case "mknodes":
-		l.Push(l.NewFunction(documentMknodes(doc.d)))
-		return 1
-	case "newpage":
-		l.Push(l.NewFunction(documentNewPage(doc.d)))
-		return 1
-	case "newfontfamily":
-		l.Push(l.NewFunction(documentNewFontfamily(doc.d)))
-		return 1
-	case "outputat":
-		l.Push(l.NewFunction(documentOutputAt(doc.d)))
-		return 1
-	case "defaultlanguage":
-		ud := newUserDataFromType(l, doc.d.DefaultLanguage)
-		l.Push(ud)
-		return 1
-	default:
-		fmt.Println("default in indexDoc", arg)
-	}
+func documentWarn(l *lua.LState) int {
+	sessionFromState(l).logger.Warnw(l.CheckString(1), logFields(l, 2)...)
 	return 0
 }
 
-func newindexDoc(l *lua.LState) int {
-	doc := checkDocument(l, 1)
-	switch arg := l.CheckString(2); arg {
-	case "defaultlanguage":
-		ud := checkPatternFile(l, 3)
-		doc.d.SetDefaultLanguage(ud)
-		return 0
-	}
+func documentError(l *lua.LState) int {
+	sessionFromState(l).logger.Errorw(l.CheckString(1), logFields(l, 2)...)
 	return 0
 }
 
@@ -142,11 +318,25 @@ func checkDocument(l *lua.LState, argpos int) *doc {
 func documentFinish(d *doc) lua.LGFunction {
 	return func(l *lua.LState) int {
 		var err error
-		if err = d.d.Finish(); err != nil {
-			return lerr(l, err.Error())
+		err = d.d.Finish()
+		checkOutputSize(l, d, "doc.finish")
+		if err != nil {
+			return etsError(l, ErrorPDF, "doc.finish", err)
 		}
 		if err = d.w.Close(); err != nil {
-			return lerr(l, err.Error())
+			return etsError(l, ErrorIO, "doc.finish", err)
+		}
+		s := sessionFromState(l)
+		if d.tofile || d.buf == nil {
+			s.Outputs = append(s.Outputs, d.d.Filename)
+		}
+		if s.Profile != nil {
+			s.Profile.addOutput(d.w.n)
+		}
+		if d.buf != nil {
+			// the PDF was rendered into memory
+			l.Push(lua.LString(d.buf.String()))
+			return 1
 		}
 		l.Push(lua.LTrue)
 		return 1
@@ -163,17 +353,50 @@ func documentHyphenate(doc *document.Document) lua.LGFunction {
 
 func documentLoadPatternFile(doc *document.Document) lua.LGFunction {
 	return func(l *lua.LState) int {
-		fn := l.CheckString(1)
-		pat, err := doc.LoadPatternFile(fn)
+		pat, err := sessionFromState(l).loadPattern(doc, l.CheckString(1))
 		if err != nil {
-			return lerr(l, err.Error())
+			return etsError(l, ErrorIO, "doc.loadpattern", err)
 		}
-		ud := newUserDataFromType(l, pat)
-		l.Push(ud)
+		l.Push(langClass.newUserData(l, pat))
 		return 1
 	}
 }
 
+// loadPattern loads the bundled patterns for the language tag name, such as de
+// or en-GB, or the pattern file name. The language gets the tag of the bundled
+// patterns as its name (de-CH becomes de-1996) and their hyphenmin values.
+func (s *Session) loadPattern(d *document.Document, name string) (*lang.Lang, error) {
+	if hyphenationpatterns.IsTag(name) {
+		if lng := hyphenationpatterns.Find(name); lng != nil {
+			fn, err := s.tempCopy(hyphenationpatterns.FS, lng.Filename(), "patterns")
+			if err != nil {
+				return nil, err
+			}
+			l, err := d.LoadPatternFile(fn)
+			if err != nil {
+				return nil, err
+			}
+			l.Name = lng.Tag
+			l.Lefthyphenmin = lng.Lefthyphenmin
+			l.Righthyphenmin = lng.Righthyphenmin
+			return l, nil
+		}
+	}
+	fn, err := s.localFile(name)
+	if err != nil {
+		if hyphenationpatterns.IsTag(name) && errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no bundled hyphenation patterns for the language %s and %w", name, err)
+		}
+		return nil, err
+	}
+	l, err := d.LoadPatternFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	s.addDependency(fn)
+	return l, nil
+}
+
 func teFromTable(l *lua.LState, tbl *lua.LTable) *document.TypesettingElement {
 	te := &document.TypesettingElement{}
 	var ts = make(document.TypesettingSettings)
@@ -247,36 +470,43 @@ func checkPatternFile(l *lua.LState, argpos int) *lang.Lang {
 	return nil
 }
 
-func newIndexLang(l *lua.LState) int {
-	n := checkPatternFile(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "name":
-		n.Name = l.CheckString(3)
-	case "lefthyphenmin":
-		n.Lefthyphenmin = l.CheckInt(3)
-	case "righthyphenmin":
-		n.Righthyphenmin = l.CheckInt(3)
-	default:
-		panic("newIndexPatternFile unknown key")
-	}
-	return 0
-}
-
-func indexLang(l *lua.LState) int {
-	return 0
-}
-
-func newUserDataFromType(l *lua.LState, n interface{}) *lua.LUserData {
-	var mt *lua.LTable
-	switch t := n.(type) {
-	case *lang.Lang:
-		mt = l.NewTypeMetatable(luaLangTypeName)
-		l.SetField(mt, "__index", l.NewFunction(indexLang))
-		l.SetField(mt, "__newindex", l.NewFunction(newIndexLang))
-		ud := l.NewUserData()
-		ud.Value = t
-		l.SetMetatable(ud, mt)
-		return ud
-	}
-	return nil
+// langClass describes the languages loaded with doc.loadpattern().
+var langClass = &luaClass{
+	name:        luaLangTypeName,
+	description: "A language represents a hyphenation pattern file. It is loaded with `doc.loadpattern()`.",
+	fields: []*luaField{
+		{
+			Value: Value{Name: "name", Type: "string", Description: "The name of the language."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LString(checkPatternFile(l, 1).Name))
+				return 1
+			},
+			newindex: func(l *lua.LState) int {
+				checkPatternFile(l, 1).Name = l.CheckString(3)
+				return 0
+			},
+		},
+		{
+			Value: Value{Name: "lefthyphenmin", Type: "number", Description: "The minimum number of characters at the beginning of a word for hyphenation."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkPatternFile(l, 1).Lefthyphenmin))
+				return 1
+			},
+			newindex: func(l *lua.LState) int {
+				checkPatternFile(l, 1).Lefthyphenmin = l.CheckInt(3)
+				return 0
+			},
+		},
+		{
+			Value: Value{Name: "righthyphenmin", Type: "number", Description: "The minimum number of characters at the end of a word for hyphenation."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkPatternFile(l, 1).Righthyphenmin))
+				return 1
+			},
+			newindex: func(l *lua.LState) int {
+				checkPatternFile(l, 1).Righthyphenmin = l.CheckInt(3)
+				return 0
+			},
+		},
+	},
 }
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLoadPatternName(t *testing.T) {
+	for _, tc := range []struct {
+		tag       string
+		name      string
+		hyphenmin string
+	}{
+		{"de", "de-1996", "2 2"},
+		{"de-DE", "de-1996", "2 2"},
+		{"de-CH", "de-1996", "2 2"},
+		{"de-CH-1901", "de-ch-1901", "2 2"},
+		{"en-GB", "en-gb", "2 3"},
+		{"pt_BR", "pt", "2 3"},
+	} {
+		src := fmt.Sprintf(`
+local d = document.new()
+local lang = assert(d.loadpattern(%q))
+assert(lang.name == %q, lang.name)
+local hyphenmin = lang.lefthyphenmin .. " " .. lang.righthyphenmin
+assert(hyphenmin == %q, hyphenmin)
+`, tc.tag, tc.name, tc.hyphenmin)
+		if err := runLua(t, src); err != nil {
+			t.Errorf("%s: %v", tc.tag, err)
+		}
+	}
+}
+
+func TestOutputOneDocument(t *testing.T) {
+	var out bytes.Buffer
+	s := &Session{
+		Luafile: "test.lua",
+		Source: `
+local d = assert(document.new())
+local ok, err = document.new("other.pdf")
+assert(not ok, "second document")
+assert(err == "only one document can be written to the output", err)
+d.newpage()
+d.currentpage().shipout()
+assert(d.finish())`,
+		Output:     &out,
+		OutputFile: "-",
+		Logger:     zap.NewNop().Sugar(),
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Outputs) != 1 || s.Outputs[0] != "-" {
+		t.Errorf("Outputs = %q, want [-]", s.Outputs)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("%PDF-")) {
+		t.Errorf("output is not a PDF file: %.20q", out.String())
+	}
+}
+
+func TestCheckPageOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = runLua(t, `
+local d = document.new()
+local page = d.newpage()
+local ok, err = pcall(getmetatable(page).__index, d, "shipout")
+assert(not ok)
+assert(err:find("page expected", 1, true), err)`)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Errorf("wrong argument for a page method writes %q to the standard output", out)
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// ErrorKind is the category of an Error.
+type ErrorKind int
+
+const (
+	// ErrorScript is an error in the Lua script, such as a syntax error or a
+	// wrong argument to an ets function.
+	ErrorScript ErrorKind = iota
+	// ErrorIO is an error reading or writing a file.
+	ErrorIO
+	// ErrorPDF is an error writing the PDF file.
+	ErrorPDF
+	// ErrorLimit is returned if the script exceeds the time limit or a
+	// limit of the output.
+	ErrorLimit
+)
+
+func (ek ErrorKind) String() string {
+	switch ek {
+	case ErrorIO:
+		return "io"
+	case ErrorPDF:
+		return "pdf"
+	case ErrorLimit:
+		return "limit"
+	}
+	return "script"
+}
+
+// MarshalText returns the name of the error kind.
+func (ek ErrorKind) MarshalText() ([]byte, error) {
+	return []byte(ek.String()), nil
+}
+
+// ExitCode returns the exit code of the process for this kind of error.
+func (ek ErrorKind) ExitCode() int {
+	switch ek {
+	case ErrorIO:
+		return 3
+	case ErrorPDF:
+		return 4
+	case ErrorLimit:
+		return 5
+	}
+	return 2
+}
+
+// Error is returned by Session.Run if the Lua script fails. It contains the
+// location of the error and the Lua stack traceback.
+type Error struct {
+	Kind ErrorKind `json:"kind"`
+	// Message is the error message without the location.
+	Message string `json:"error"`
+	// Function is the ets function that caused the error, for example
+	// node.linebreak or doc.loadFace.
+	Function string `json:"function,omitempty"`
+	Filename string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	// Column is the position (starting with 1) in the source line the error
+	// refers to or 0 if unknown.
+	Column     int    `json:"column,omitempty"`
+	SourceLine string `json:"source,omitempty"`
+	Traceback  string `json:"traceback,omitempty"`
+	Err        error  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Filename == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Message)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Report returns a detailed description of the error with the source line
+// and the Lua stack traceback.
+func (e *Error) Report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s error: %s\n", e.Kind, e.Error())
+	if e.Function != "" {
+		fmt.Fprintf(&b, "in ets function %s\n", e.Function)
+	}
+	if e.SourceLine != "" {
+		prefix := fmt.Sprintf("%5d | ", e.Line)
+		fmt.Fprintf(&b, "\n%s%s\n", prefix, e.SourceLine)
+		if e.Column > 0 {
+			// keep tabs so the caret lines up with the source line
+			indent := []rune(e.SourceLine)
+			if e.Column-1 < len(indent) {
+				indent = indent[:e.Column-1]
+			}
+			for i, r := range indent {
+				if r != '\t' {
+					indent[i] = ' '
+				}
+			}
+			fmt.Fprintf(&b, "%s%s^\n", strings.Repeat(" ", len(prefix)), string(indent))
+		}
+	}
+	if e.Traceback != "" {
+		fmt.Fprintf(&b, "\n%s\n", e.Traceback)
+	}
+	return b.String()
+}
+
+var (
+	locationRE     = regexp.MustCompile(`^(.+?):(\d+): (?s)(.*)$`)
+	tracebackRE    = regexp.MustCompile(`(?m)^\s*([^\[\s][^:]*):(\d+): in `)
+	badArgumentRE  = regexp.MustCompile(`^bad argument #\d+ to '?([\w.]+)'?`)
+	gofunctionRE   = regexp.MustCompile(`\[G\]: in function '([\w.]+)'`)
+	lastIdentifier = regexp.MustCompile(`[\w.]+$`)
+	unknownFieldRE = regexp.MustCompile(`unknown field (\w+)`)
+)
+
+// etsError records err as the cause of a failed ets function. If the script
+// fails afterwards because of this error (for example by calling os.exit()),
+// the error report contains the function name and the kind of error.
+func etsError(l *lua.LState, kind ErrorKind, function string, err error) int {
+	sessionFromState(l).lastError = &Error{Kind: kind, Function: function, Message: err.Error(), Err: err}
+	return lerr(l, err.Error())
+}
+
+// newError converts an error returned from running Lua code into an *Error.
+func (s *Session) newError(err error) error {
+	var apierr *lua.ApiError
+	if !errors.As(err, &apierr) {
+		return err
+	}
+	e := &Error{Kind: ErrorScript, Err: err, Traceback: apierr.StackTrace}
+	var perr *parse.Error
+	switch {
+	case apierr.Type == lua.ApiErrorFile:
+		e.Kind = ErrorIO
+		e.Message = apierr.Object.String()
+		return e
+	case errors.As(apierr.Cause, &perr):
+		e.Filename = perr.Pos.Source
+		e.Line = perr.Pos.Line
+		e.Column = perr.Pos.Column
+		e.Message = perr.Message
+		if perr.Token != "" {
+			e.Message = fmt.Sprintf("%s near '%s'", perr.Message, perr.Token)
+		}
+	default:
+		e.Message = apierr.Object.String()
+		if m := locationRE.FindStringSubmatch(e.Message); m != nil {
+			e.Filename = m[1]
+			e.Line, _ = strconv.Atoi(m[2])
+			e.Message = m[3]
+		}
+		if ee := exitCode(err); ee != nil {
+			e.Message = ee.Error()
+			e.Err = ee
+		}
+		if m := tracebackRE.FindStringSubmatch(e.Traceback); m != nil {
+			e.Filename = m[1]
+			e.Line, _ = strconv.Atoi(m[2])
+		}
+		if m := badArgumentRE.FindStringSubmatch(e.Message); m != nil {
+			e.Function = m[1]
+		} else if m := gofunctionRE.FindStringSubmatch(e.Traceback); m != nil {
+			e.Function = m[1]
+		}
+	}
+	e.readSourceLine(s.readLuaFile)
+	defer s.contextError(e)
+	function := e.Function
+	if function != "" {
+		if col := strings.Index(e.SourceLine, function); col >= 0 {
+			e.Column = col + 1
+		}
+		e.Function = qualifiedFunctionName(function, e.SourceLine)
+	}
+	if m := unknownFieldRE.FindStringSubmatch(e.Message); m != nil && e.Column == 0 {
+		if col := strings.Index(e.SourceLine, "."+m[1]); col >= 0 {
+			e.Column = col + 2
+		}
+	}
+	if le := s.lastError; le != nil {
+		// An ets function returned an error before. If the script stops
+		// because of this error (with os.exit() or error()), the kind of
+		// error is taken from it, otherwise it is just mentioned.
+		if function == "exit" || function == "error" || strings.Contains(e.Message, le.Message) {
+			e.Kind = le.Kind
+			e.Function = le.Function
+		}
+		if !strings.Contains(e.Message, le.Message) {
+			e.Message = fmt.Sprintf("%s (after %s failed: %s)", e.Message, le.Function, le.Message)
+		}
+	}
+	return e
+}
+
+// readSourceLine sets the source line from the file read with readFile.
+func (e *Error) readSourceLine(readFile func(string) ([]byte, error)) {
+	if e.Filename == "" {
+		return
+	}
+	data, err := readFile(e.Filename)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	if e.Line < 1 {
+		// syntax error at the end of the file
+		e.Line = len(lines)
+		for e.Line > 1 && strings.TrimSpace(lines[e.Line-1]) == "" {
+			e.Line--
+		}
+		e.Column = 0
+	}
+	if e.Line <= len(lines) {
+		e.SourceLine = strings.TrimRight(lines[e.Line-1], "\r")
+	}
+}
+
+// qualifiedFunctionName returns the name of the ets function as it is
+// documented, such as node.linebreak or doc.createFont. The source line is
+// used to tell functions with the same name apart. The empty string is
+// returned if name is not an ets function.
+func qualifiedFunctionName(name string, sourceline string) string {
+	name = lastIdentifier.FindString(name)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	isNode := nodeLibrary.function(name) != nil
+	isDocument := documentLibrary.function(name) != nil
+	isDoc := docClass.method(name) != nil
+	switch {
+	case jsonLibrary.function(name) != nil && strings.Contains(sourceline, "json."+name):
+		return "json." + name
+	case isNode && (!isDocument || strings.Contains(sourceline, "node."+name)):
+		return "node." + name
+	case isDocument && strings.Contains(sourceline, "document."+name):
+		return "document." + name
+	case isDoc:
+		return "doc." + name
+	case isDocument && name != "error":
+		// error without document. is the Lua function
+		return "document." + name
+	}
+	return ""
+}
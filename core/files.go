@@ -0,0 +1,194 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// OpenFS returns the file system for Session.FS: the directory name or the
+// zip archive name (if the file name ends with .zip). The zip archive must be
+// closed by the caller, the file system implements io.Closer in this case.
+func OpenFS(name string) (fs.FS, error) {
+	if strings.EqualFold(filepath.Ext(name), ".zip") {
+		return zip.OpenReader(name)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is neither a directory nor a zip archive", name)
+	}
+	return os.DirFS(name), nil
+}
+
+// MemFS returns a file system with the files in memory. The keys are the file
+// names with slashes as separators, such as fonts/regular.ttf.
+func MemFS(files map[string][]byte) (fs.FS, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fsName(name), Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// fsName converts the file name into a name for the file system FS, which
+// uses slashes and no leading slash.
+func fsName(fn string) string {
+	name := path.Clean(filepath.ToSlash(fn))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// readLuaFile returns the contents of the Lua file fn. The Lua file of the
+// session is taken from Source if set, all files are read from FS if set.
+func (s *Session) readLuaFile(fn string) ([]byte, error) {
+	switch {
+	case fn == s.Luafile && s.Source != "":
+		return []byte(s.Source), nil
+	case s.FS != nil:
+		return fs.ReadFile(s.FS, fsName(fn))
+	}
+	return os.ReadFile(fn)
+}
+
+// stat returns the file info of the file fn in FS or, without FS, in the file
+// system of the operating system.
+func (s *Session) stat(fn string) (fs.FileInfo, error) {
+	if s.FS != nil {
+		return fs.Stat(s.FS, fsName(fn))
+	}
+	return os.Stat(fn)
+}
+
+// loadLuaFile compiles the Lua file fn, see readLuaFile.
+func (s *Session) loadLuaFile(l *lua.LState, fn string) (*lua.LFunction, error) {
+	if s.FS == nil && s.Source == "" {
+		return l.LoadFile(fn)
+	}
+	data, err := s.readLuaFile(fn)
+	if err != nil {
+		return nil, &lua.ApiError{Type: lua.ApiErrorFile, Object: lua.LString(err.Error()), Cause: err}
+	}
+	return l.Load(bytes.NewReader(data), fn)
+}
+
+// doLuaFile runs the Lua file fn, see readLuaFile.
+func (s *Session) doLuaFile(l *lua.LState, fn string) error {
+	f, err := s.loadLuaFile(l, fn)
+	if err != nil {
+		return err
+	}
+	l.Push(f)
+	return l.PCall(0, lua.MultRet, nil)
+}
+
+// localFile returns the name of the font, image or pattern file fn for the
+// typesetting library, which only reads files from the operating system. The
+// file is searched with find. Files from FS are copied into a temporary
+// directory which is removed at the end of the run.
+func (s *Session) localFile(fn string) (string, error) {
+	name, err := s.find(fn)
+	if err != nil {
+		return "", err
+	}
+	if s.FS == nil {
+		if err := s.checkFile(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	return s.tempCopy(s.FS, fsName(name), "fs")
+}
+
+// open opens the data file fn, which is searched with find, from FS or the
+// file system of the operating system. The caller must close the file.
+func (s *Session) open(fn string) (io.ReadCloser, error) {
+	name, err := s.find(fn)
+	if err != nil {
+		return nil, err
+	}
+	if s.FS != nil {
+		return s.FS.Open(fsName(name))
+	}
+	if err := s.checkFile(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s.addDependency(name)
+	return f, nil
+}
+
+// tempCopy copies the file name from fsys into the directory dir in the
+// temporary directory of the run and returns the name of the copy. A file is
+// copied only once per run.
+func (s *Session) tempCopy(fsys fs.FS, name string, dir string) (string, error) {
+	if s.tempDir == "" {
+		tmp, err := os.MkdirTemp("", "ets")
+		if err != nil {
+			return "", err
+		}
+		s.tempDir = tmp
+	}
+	local := filepath.Join(s.tempDir, dir, filepath.FromSlash(name))
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	src, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	if err = os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return "", err
+	}
+	dst, err := os.Create(local)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return local, dst.Close()
+}
+
+// removeTempDir removes the copies of the files from FS and of the bundled
+// pattern files.
+func (s *Session) removeTempDir() {
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+		s.tempDir = ""
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+)
+
+func TestOpenFS(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "main.lua"), "-- main")
+	writeTestFile(t, filepath.Join(dir, "img", "logo.png"), "png")
+	archive := filepath.Join(t.TempDir(), "job.ZIP")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range map[string]string{"main.lua": "-- main", "img/logo.png": "png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, name := range []string{dir, archive} {
+		fsys, err := OpenFS(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fstest.TestFS(fsys, "main.lua", "img/logo.png"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if c, ok := fsys.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	for _, name := range []string{filepath.Join(dir, "main.lua"), filepath.Join(dir, "missing")} {
+		if _, err := OpenFS(name); err == nil {
+			t.Errorf("OpenFS(%s): no error", name)
+		}
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fsys, err := MemFS(map[string][]byte{
+		"main.lua":          []byte("-- main"),
+		"fonts/regular.ttf": []byte("font"),
+		"/img/logo.png":     []byte("png"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fstest.TestFS(fsys, "main.lua", "fonts/regular.ttf", "img/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(fsys, "fonts/regular.ttf")
+	if err != nil || string(data) != "font" {
+		t.Errorf("fonts/regular.ttf: %q %v, want \"font\"", data, err)
+	}
+}
+
+func TestLocalFileFS(t *testing.T) {
+	font, err := os.ReadFile(filepath.Join("..", "fonts", "CrimsonPro-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := MemFS(map[string][]byte{"fonts/regular.ttf": font})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the loader of the module tempdir records the temporary directory of the
+	// run
+	var tempDir string
+	s := &Session{
+		Luafile: "test.lua",
+		FS:      fsys,
+		Logger:  zap.NewNop().Sugar(),
+		Modules: map[string]lua.LGFunction{"tempdir": func(l *lua.LState) int {
+			tempDir = sessionFromState(l).tempDir
+			return 0
+		}},
+		Source: `
+local d = document.new("out.pdf")
+assert(d.loadFace({name = "regular", source = "fonts/regular.ttf"}))
+assert(d.loadFace({name = "again", source = "fonts/regular.ttf"}))
+require("tempdir")`,
+	}
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if tempDir == "" {
+		t.Fatal("no temporary directory for the files from FS")
+	}
+	if _, err = os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("the temporary directory %s is not removed: %v", tempDir, err)
+	}
+	for _, dep := range s.Dependencies {
+		if strings.HasPrefix(dep, tempDir) {
+			t.Errorf("the copy %s is a dependency", dep)
+		}
+	}
+}
+
+func TestTempCopy(t *testing.T) {
+	fsys := fstest.MapFS{"fonts/regular.ttf": {Data: []byte("font")}}
+	s := &Session{FS: fsys}
+	defer s.removeTempDir()
+	fn, err := s.localFile("fonts/regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(fn); err != nil || string(data) != "font" {
+		t.Errorf("copy %s: %q %v, want \"font\"", fn, data, err)
+	}
+	again, err := s.localFile("fonts/regular.ttf")
+	if err != nil || again != fn {
+		t.Errorf("second copy: %s %v, want %s", again, err, fn)
+	}
+	if _, err = s.localFile("fonts/missing.ttf"); err == nil {
+		t.Error("missing file: no error")
+	}
+	s.removeTempDir()
+	if _, err = os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("the copy %s is not removed: %v", fn, err)
+	}
+}
@@ -25,19 +25,20 @@ func documentLoadFace(doc *document.Document) lua.LGFunction {
 		if srcValue.Type() != lua.LTString {
 			return lerr(l, "the value of source must be a string")
 		}
+		source, err := sessionFromState(l).localFile(srcValue.String())
+		if err != nil {
+			return etsError(l, ErrorIO, "doc.loadFace", err)
+		}
 		fs := document.FontSource{
 			Name:   nameValue.String(),
-			Source: srcValue.String(),
+			Source: source,
 		}
 		f, err := doc.LoadFace(&fs)
 		if err != nil {
-			return lerr(l, err.Error())
+			return etsError(l, ErrorIO, "doc.loadFace", err)
 		}
-		mt := l.NewTypeMetatable(luaFaceTypeName)
-		ud := l.NewUserData()
-		ud.Value = f
-		l.SetMetatable(ud, mt)
-		l.Push(ud)
+		sessionFromState(l).addDependency(fs.Source)
+		l.Push(faceClass.newUserData(l, f))
 		return 1
 	}
 }
@@ -74,12 +75,7 @@ func documentCreateFont(doc *document.Document) lua.LGFunction {
 		face := checkFace(l, 1)
 		size := l.CheckNumber(2)
 		fnt := doc.CreateFont(face, bag.ScaledPoint(size))
-		mt := l.NewTypeMetatable(luaFontTypeName)
-		l.SetField(mt, "__index", l.NewFunction(indexFont))
-		ud := l.NewUserData()
-		ud.Value = fnt
-		l.SetMetatable(ud, mt)
-		l.Push(ud)
+		l.Push(fontClass.newUserData(l, fnt))
 		return 1
 	}
 }
@@ -105,28 +101,54 @@ func fontShape(fnt *font.Font, fntObj lua.LValue) lua.LGFunction {
 	}
 }
 
-func indexFont(l *lua.LState) int {
-	f := checkFont(l, 1)
-	fontObj := l.Get(1)
-	arg := l.CheckString(2)
-	switch arg {
-	case "size":
-		l.Push(lua.LNumber(f.Size))
-		return 1
-	case "space":
-		l.Push(lua.LNumber(f.Space))
-		return 1
-	case "stretch":
-		l.Push(lua.LNumber(f.SpaceStretch))
-		return 1
-	case "shrink":
-		l.Push(lua.LNumber(f.SpaceShrink))
-		return 1
-	case "shape":
-		l.Push(l.NewFunction(fontShape(f, fontObj)))
-		return 1
-	}
-	return 0
+// faceClass describes the font faces loaded with doc.loadFace().
+var faceClass = &luaClass{
+	name:        luaFaceTypeName,
+	description: "A font face represents a font file. It is loaded with `doc.loadFace()`.",
+}
+
+// fontClass describes the font instances created with doc.createFont().
+var fontClass = &luaClass{
+	name:        luaFontTypeName,
+	description: "A font instance is a font face in a size. It is created with `doc.createFont()`.",
+	methods: []*luaMethod{
+		{Function{
+			Name:        "shape",
+			Args:        []Value{{Name: "text", Type: "string", Description: "The text to shape."}},
+			Returns:     []Value{{Name: "glyphs", Type: "table", Description: "A list of tables with the fields `codepoint`, `advance`, `components`, `glyph`, `hyphenate`, `isspace` and `font`."}},
+			Description: "Convert the text into a list of glyphs of the font.",
+		}, func(l *lua.LState) lua.LGFunction { return fontShape(checkFont(l, 1), l.Get(1)) }},
+	},
+	fields: []*luaField{
+		{
+			Value: Value{Name: "size", Type: "number", Description: "The font size in scaled points."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkFont(l, 1).Size))
+				return 1
+			},
+		},
+		{
+			Value: Value{Name: "space", Type: "number", Description: "The width of a space in scaled points."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkFont(l, 1).Space))
+				return 1
+			},
+		},
+		{
+			Value: Value{Name: "stretch", Type: "number", Description: "The stretchability of a space in scaled points."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkFont(l, 1).SpaceStretch))
+				return 1
+			},
+		},
+		{
+			Value: Value{Name: "shrink", Type: "number", Description: "The shrinkability of a space in scaled points."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkFont(l, 1).SpaceShrink))
+				return 1
+			},
+		},
+	},
 }
 
 // Font families
@@ -148,26 +170,36 @@ func checkFontfamily(l *lua.LState, argpos int) *document.FontFamily {
 	return nil
 }
 
-func newUserdataFontfamily(l *lua.LState, ff *document.FontFamily) *lua.LUserData {
-	ud := l.NewUserData()
-	ud.Value = ff
-	mt := l.NewTypeMetatable(luaFontFamilyTypeName)
-	l.SetField(mt, "__index", l.NewFunction(fontfamilyIndex))
-	l.SetMetatable(ud, mt)
-	return ud
+// fontfamilyClass describes the font families created with
+// doc.newfontfamily().
+var fontfamilyClass = &luaClass{
+	name:        luaFontFamilyTypeName,
+	description: "A font family is a set of fonts in different weights and styles. It is created with `doc.newfontfamily()`.",
+	methods: []*luaMethod{
+		{Function{
+			Name: "addmember",
+			Args: []Value{
+				fontSourceArg,
+				{Name: "weight", Type: "number", Description: "The font weight such as 400 (regular) or 700 (bold)."},
+				{Name: "style", Type: "string", Description: "`regular`, `normal` or `italic`."},
+			},
+			Returns:     []Value{{Name: "ok", Type: "false", Optional: true, Description: "`false` if the font source is invalid."}, errorReturn},
+			Description: "Add a font to the family.",
+		}, func(l *lua.LState) lua.LGFunction { return fontfamilyaddmember(checkFontfamily(l, 1)) }},
+	},
+	fields: []*luaField{
+		{
+			Value: Value{Name: "id", Type: "number", Description: "The internal number of the font family."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkFontfamily(l, 1).ID))
+				return 1
+			},
+		},
+	},
 }
 
-func fontfamilyIndex(l *lua.LState) int {
-	ff := checkFontfamily(l, 1)
-	switch l.CheckString(2) {
-	case "addmember":
-		l.Push(l.NewFunction(fontfamilyaddmember(ff)))
-		return 1
-	case "id":
-		l.Push(lua.LNumber(ff.ID))
-		return 1
-	}
-	return 0
+func newUserdataFontfamily(l *lua.LState, ff *document.FontFamily) *lua.LUserData {
+	return fontfamilyClass.newUserData(l, ff)
 }
 
 func fontfamilyaddmember(p *document.FontFamily) lua.LGFunction {
@@ -181,9 +213,13 @@ func fontfamilyaddmember(p *document.FontFamily) lua.LGFunction {
 		if srcValue.Type() != lua.LTString {
 			return lerr(l, "the value of source must be a string")
 		}
+		source, err := sessionFromState(l).localFile(srcValue.String())
+		if err != nil {
+			return etsError(l, ErrorIO, "fontfamily.addmember", err)
+		}
 		fs := &document.FontSource{
 			Name:   nameValue.String(),
-			Source: srcValue.String(),
+			Source: source,
 		}
 
 		weight := l.CheckInt(2)
@@ -196,6 +232,7 @@ func fontfamilyaddmember(p *document.FontFamily) lua.LGFunction {
 			style = document.FontStyleItalic
 		}
 		p.AddMember(fs, weight, style)
+		sessionFromState(l).addDependency(fs.Source)
 		return 0
 	}
 }
@@ -14,6 +14,17 @@ func lerr(l *lua.LState, errormessage string) int {
 	return 2
 }
 
+// checkTableNumber returns the number in the field key of the table tbl which
+// is the argument at argpos.
+func checkTableNumber(l *lua.LState, argpos int, tbl *lua.LTable, key string) lua.LNumber {
+	lv := tbl.RawGetString(key)
+	if n, ok := lv.(lua.LNumber); ok {
+		return n
+	}
+	l.ArgError(argpos, fmt.Sprintf("number expected in field %s, got %s", key, lv.Type()))
+	return 0
+}
+
 // for debugging
 func stackDump(l *lua.LState) {
 	fmt.Println("-------stack------")
@@ -33,17 +33,20 @@ func checkImagefile(l *lua.LState, argpos int) *pdf.Imagefile {
 
 func documentLoadImageFile(doc *document.Document) lua.LGFunction {
 	return func(l *lua.LState) int {
-		fn := l.CheckString(1)
+		fn, err := sessionFromState(l).localFile(l.CheckString(1))
+		if err != nil {
+			return etsError(l, ErrorIO, "doc.loadimagefile", err)
+		}
 		dif, err := doc.LoadImageFile(fn)
 		if err != nil {
-			return lerr(l, err.Error())
+			return etsError(l, ErrorIO, "doc.loadimagefile", err)
 		}
-		mt := l.NewTypeMetatable(luaImageFileTypeName)
-		l.SetField(mt, "__index", l.NewFunction(indexImageFile))
-		ud := l.NewUserData()
-		ud.Value = dif
-		l.SetMetatable(ud, mt)
-		l.Push(ud)
+		if s := sessionFromState(l); s.FS != nil {
+			// the name in FS instead of the temporary copy
+			dif.Filename, _ = s.find(l.CheckString(1))
+		}
+		sessionFromState(l).addDependency(fn)
+		l.Push(imagefileClass.newUserData(l, dif))
 		return 1
 	}
 }
@@ -52,13 +55,7 @@ func documentCreateImage(doc *document.Document) lua.LGFunction {
 	return func(l *lua.LState) int {
 		imgf := checkImagefile(l, 1)
 		img := doc.CreateImage(imgf)
-		mt := l.NewTypeMetatable(luaImageTypeName)
-		l.SetField(mt, "__index", l.NewFunction(indexImage))
-		// l.SetField(mt, "__newindex", l.NewFunction(newIndexImage))
-		ud := l.NewUserData()
-		ud.Value = img
-		l.SetMetatable(ud, mt)
-		l.Push(ud)
+		l.Push(imageClass.newUserData(l, img))
 		return 1
 	}
 }
@@ -73,23 +70,37 @@ func documentCreateImage(doc *document.Document) lua.LGFunction {
 // 	return 0
 // }
 
-func indexImage(l *lua.LState) int {
-	return 0
+// imageClass describes the image instances created with doc.createimage().
+var imageClass = &luaClass{
+	name:        luaImageTypeName,
+	description: "An instance of an image file from `doc.createimage()`.",
 }
 
-func indexImageFile(l *lua.LState) int {
-	imgf := checkImagefile(l, 1)
-	arg := l.ToString(2)
-	switch arg {
-	case "format":
-		l.Push(lua.LString(imgf.Format))
-		return 1
-	case "numberOfPages":
-		l.Push(lua.LNumber(imgf.NumberOfPages))
-		return 1
-	case "filename":
-		l.Push(lua.LString(imgf.Filename))
-		return 1
-	}
-	return 0
+// imagefileClass describes the image files loaded with doc.loadimagefile().
+var imagefileClass = &luaClass{
+	name:        luaImageFileTypeName,
+	description: "An image file represents a physical image. It is loaded with `doc.loadimagefile()`.",
+	fields: []*luaField{
+		{
+			Value: Value{Name: "format", Type: "string", Description: "The format of the image file such as `pdf`, `png` or `jpg`."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LString(checkImagefile(l, 1).Format))
+				return 1
+			},
+		},
+		{
+			Value: Value{Name: "numberOfPages", Type: "number", Description: "The number of pages of a PDF file."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LNumber(checkImagefile(l, 1).NumberOfPages))
+				return 1
+			},
+		},
+		{
+			Value: Value{Name: "filename", Type: "string", Description: "The file name of the image."},
+			index: func(l *lua.LState) int {
+				l.Push(lua.LString(checkImagefile(l, 1).Filename))
+				return 1
+			},
+		},
+	},
 }
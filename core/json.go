@@ -0,0 +1,515 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaJSONTypeName = "json"
+	// jsonKeysField is the field in the metatable of an ordered object with
+	// the keys in the order of insertion.
+	jsonKeysField = "__jsonkeys"
+	// jsonRegistryKey is the registry field with the __newindex function of
+	// ordered objects.
+	jsonRegistryKey = "ets.json.newindex"
+)
+
+// jsonNull is the value of the user data json.null.
+type jsonNull struct{}
+
+var jsonDecodeOptions = Value{Name: "options", Type: "table", Optional: true, Description: "`ordered = true` keeps the order of the keys of objects (see `json.object()`), `null = true` decodes null as `json.null` instead of nil."}
+
+// jsonLibrary is the global table json.
+var jsonLibrary = &luaLibrary{
+	name:        luaJSONTypeName,
+	className:   "jsonlib",
+	description: "The json library converts between JSON and Lua values. Objects become tables with string keys and arrays tables with the indexes 1 to n.",
+	functions: []*luaFunction{
+		{Function{
+			Name:        "decode",
+			Args:        []Value{{Name: "text", Type: "string", Description: "The JSON text."}, jsonDecodeOptions},
+			Returns:     []Value{{Name: "value", Type: "any", Description: "The decoded value. The JSON text `false` also returns false, check err."}, errorReturn},
+			Description: "Decode a JSON text.",
+		}, jsonDecode},
+		{Function{
+			Name: "decodefile",
+			Args: []Value{
+				{Name: "filename", Type: "string", Description: "The name of the JSON file. The file is searched like fonts and images."},
+				{Name: "options", Type: "table", Optional: true, Description: "The options of `json.decode()` and `each`, a function that is called with each element of the array in the file and its index. The array is not kept in memory and the function can return false to stop reading."},
+			},
+			Returns:     []Value{{Name: "value", Type: "any", Description: "The decoded value or with `each` the number of elements read. The JSON text `false` also returns false, check err."}, errorReturn},
+			Description: "Decode a JSON file while it is read.",
+		}, jsonDecodeFile},
+		{Function{
+			Name: "encode",
+			Args: []Value{
+				{Name: "value", Type: "any", Description: "A table, string, number, boolean, nil or `json.null`."},
+				{Name: "options", Type: "table", Optional: true, Description: "`pretty = true` writes one value per line, indented by `indent` (default two spaces)."},
+			},
+			Returns:     []Value{{Name: "text", Type: "string|false", Description: "The JSON text."}, errorReturn},
+			Description: "Encode a value as JSON. Empty tables become empty arrays and tables with the keys 1 to n arrays. The keys of other tables are sorted, the keys of ordered objects are kept in order.",
+		}, jsonEncode},
+		{Function{
+			Name:        "object",
+			Returns:     []Value{{Name: "object", Type: "table", Description: "The empty object."}},
+			Description: "Create an empty ordered object. The keys are encoded and iterated with `json.pairs()` in the order they are first set. An empty object is encoded as {}.",
+		}, jsonObject},
+		{Function{
+			Name:        "pairs",
+			Args:        []Value{{Name: "tbl", Type: "table", Description: "The table."}},
+			Returns:     []Value{{Name: "iterator", Type: "function", Description: "The iterator for a generic for loop."}},
+			Description: "Iterate over the keys and values of the table in the order of `json.encode()`.",
+		}, jsonPairs},
+	},
+	fields: []Value{
+		{Name: "null", Type: "userdata", Description: "The JSON value null, which can't be stored in a table as nil."},
+	},
+}
+
+// registerJSON creates the global table json.
+func registerJSON(l *lua.LState) {
+	mt := jsonLibrary.register(l)
+	null := l.NewUserData()
+	null.Value = jsonNull{}
+	nullmt := l.NewTable()
+	l.SetField(nullmt, "__tostring", l.NewFunction(func(l *lua.LState) int {
+		l.Push(lua.LString("null"))
+		return 1
+	}))
+	l.SetMetatable(null, nullmt)
+	l.SetField(mt, "null", null)
+	l.SetField(l.G.Registry, jsonRegistryKey, l.NewFunction(jsonObjectNewIndex))
+}
+
+// jsonOptions are the options of decode and decodefile.
+type jsonOptions struct {
+	ordered bool
+	null    lua.LValue
+}
+
+// checkJSONOptions returns the options table at argpos or nil.
+func checkJSONOptions(l *lua.LState, argpos int) *lua.LTable {
+	if l.Get(argpos) == lua.LNil {
+		return nil
+	}
+	return l.CheckTable(argpos)
+}
+
+func newJSONOptions(l *lua.LState, tbl *lua.LTable) jsonOptions {
+	opts := jsonOptions{null: lua.LNil}
+	if tbl == nil {
+		return opts
+	}
+	opts.ordered = lua.LVAsBool(tbl.RawGetString("ordered"))
+	if lua.LVAsBool(tbl.RawGetString("null")) {
+		opts.null = l.GetField(l.GetGlobal(luaJSONTypeName), "null")
+	}
+	return opts
+}
+
+func jsonDecode(l *lua.LState) int {
+	text := l.CheckString(1)
+	opts := newJSONOptions(l, checkJSONOptions(l, 2))
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	v, err := decodeJSONValue(l, dec, opts)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			l.Push(v)
+			return 1
+		}
+		if err == nil {
+			err = errors.New("data after the JSON value")
+		}
+	}
+	return etsError(l, ErrorScript, "json.decode", jsonSyntaxError(dec, err))
+}
+
+func jsonDecodeFile(l *lua.LState) int {
+	fn := l.CheckString(1)
+	tbl := checkJSONOptions(l, 2)
+	opts := newJSONOptions(l, tbl)
+	var each *lua.LFunction
+	if tbl != nil {
+		if lv := tbl.RawGetString("each"); lv != lua.LNil {
+			f, ok := lv.(*lua.LFunction)
+			if !ok {
+				l.ArgError(2, fmt.Sprintf("function expected in field each, got %s", lv.Type()))
+			}
+			each = f
+		}
+	}
+	r, err := sessionFromState(l).open(fn)
+	if err != nil {
+		return etsError(l, ErrorIO, "json.decodefile", err)
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var v lua.LValue
+	if each == nil {
+		v, err = decodeJSONValue(l, dec, opts)
+	} else {
+		v, err = decodeJSONEach(l, dec, opts, each)
+	}
+	if err != nil {
+		return etsError(l, ErrorIO, "json.decodefile", fmt.Errorf("%s: %w", fn, jsonSyntaxError(dec, err)))
+	}
+	l.Push(v)
+	return 1
+}
+
+// jsonSyntaxError adds the position in the input to the error message.
+func jsonSyntaxError(dec *json.Decoder, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("unexpected end of JSON input")
+	}
+	return fmt.Errorf("%w at byte offset %d", err, dec.InputOffset())
+}
+
+// decodeJSONEach calls each with the elements of the array and their index
+// and returns the number of elements.
+func decodeJSONEach(l *lua.LState, dec *json.Decoder, opts jsonOptions, each *lua.LFunction) (lua.LValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('[') {
+		return nil, errors.New("each needs a JSON array")
+	}
+	n := 0
+	for dec.More() {
+		v, err := decodeJSONValue(l, dec, opts)
+		if err != nil {
+			return nil, err
+		}
+		n++
+		l.Push(each)
+		l.Push(v)
+		l.Push(lua.LNumber(n))
+		l.Call(2, 1)
+		ret := l.Get(-1)
+		l.Pop(1)
+		if ret == lua.LFalse {
+			return lua.LNumber(n), nil
+		}
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	return lua.LNumber(n), nil
+}
+
+// decodeJSONValue reads the next value from the decoder token by token, so
+// large files are not kept in memory twice.
+func decodeJSONValue(l *lua.LState, dec *json.Decoder, opts jsonOptions) (lua.LValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			tbl := l.NewTable()
+			i := 0
+			for dec.More() {
+				v, err := decodeJSONValue(l, dec, opts)
+				if err != nil {
+					return nil, err
+				}
+				i++
+				tbl.RawSetInt(i, v)
+			}
+			_, err = dec.Token()
+			return tbl, err
+		}
+		var tbl *lua.LTable
+		var keys *lua.LTable
+		if opts.ordered {
+			tbl, keys = newJSONObject(l)
+		} else {
+			tbl = l.NewTable()
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := lua.LString(tok.(string))
+			v, err := decodeJSONValue(l, dec, opts)
+			if err != nil {
+				return nil, err
+			}
+			if v == lua.LNil {
+				continue
+			}
+			if keys != nil && tbl.RawGet(key) == lua.LNil {
+				keys.Append(key)
+			}
+			tbl.RawSet(key, v)
+		}
+		_, err = dec.Token()
+		return tbl, err
+	case string:
+		return lua.LString(t), nil
+	case json.Number:
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		return lua.LNumber(f), nil
+	case bool:
+		return lua.LBool(t), nil
+	}
+	return opts.null, nil
+}
+
+// newJSONObject returns an ordered object and the table of its keys.
+func newJSONObject(l *lua.LState) (*lua.LTable, *lua.LTable) {
+	tbl := l.NewTable()
+	keys := l.NewTable()
+	mt := l.NewTable()
+	mt.RawSetString(jsonKeysField, keys)
+	mt.RawSetString("__newindex", l.GetField(l.G.Registry, jsonRegistryKey))
+	l.SetMetatable(tbl, mt)
+	return tbl, keys
+}
+
+// jsonObjectNewIndex records a new key of an ordered object.
+func jsonObjectNewIndex(l *lua.LState) int {
+	tbl := l.CheckTable(1)
+	key := l.CheckAny(2)
+	v := l.Get(3)
+	if v != lua.LNil {
+		if keys := jsonObjectKeys(l, tbl); keys != nil {
+			keys.Append(key)
+		}
+	}
+	tbl.RawSet(key, v)
+	return 0
+}
+
+// jsonObjectKeys returns the table of keys if tbl is an ordered object.
+func jsonObjectKeys(l *lua.LState, tbl *lua.LTable) *lua.LTable {
+	if mt, ok := l.GetMetatable(tbl).(*lua.LTable); ok {
+		if keys, ok := mt.RawGetString(jsonKeysField).(*lua.LTable); ok {
+			return keys
+		}
+	}
+	return nil
+}
+
+func jsonObject(l *lua.LState) int {
+	tbl, _ := newJSONObject(l)
+	l.Push(tbl)
+	return 1
+}
+
+// jsonTableKeys returns the keys of the table in the order of the encoding
+// and whether the table is an array. The keys of an ordered object are in
+// the order of insertion without the keys that have been removed.
+func jsonTableKeys(l *lua.LState, tbl *lua.LTable) ([]lua.LValue, bool, error) {
+	if keys := jsonObjectKeys(l, tbl); keys != nil {
+		var ret []lua.LValue
+		seen := map[lua.LValue]bool{}
+		for i := 1; i <= keys.Len(); i++ {
+			key := keys.RawGetInt(i)
+			if !seen[key] && tbl.RawGet(key) != lua.LNil {
+				seen[key] = true
+				ret = append(ret, key)
+			}
+		}
+		return ret, false, nil
+	}
+	var keys []lua.LValue
+	isArray := true
+	var err error
+	tbl.ForEach(func(key, _ lua.LValue) {
+		switch k := key.(type) {
+		case lua.LString:
+			isArray = false
+		case lua.LNumber:
+			if k != lua.LNumber(math.Trunc(float64(k))) || k < 1 {
+				isArray = false
+			}
+		default:
+			err = fmt.Errorf("a table key of type %s can't be encoded", key.Type())
+		}
+		keys = append(keys, key)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if isArray {
+		// keys 1 to n
+		for _, key := range keys {
+			if int(key.(lua.LNumber)) > len(keys) {
+				isArray = false
+				break
+			}
+		}
+	}
+	if isArray {
+		for i := range keys {
+			keys[i] = lua.LNumber(i + 1)
+		}
+		return keys, true, nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys, false, nil
+}
+
+func jsonPairs(l *lua.LState) int {
+	tbl := l.CheckTable(1)
+	keys, _, err := jsonTableKeys(l, tbl)
+	if err != nil {
+		l.ArgError(1, err.Error())
+	}
+	i := 0
+	l.Push(l.NewFunction(func(l *lua.LState) int {
+		for i < len(keys) {
+			key := keys[i]
+			i++
+			if v := tbl.RawGet(key); v != lua.LNil {
+				l.Push(key)
+				l.Push(v)
+				return 2
+			}
+		}
+		return 0
+	}))
+	return 1
+}
+
+// jsonEncoder writes Lua values as JSON.
+type jsonEncoder struct {
+	l      *lua.LState
+	buf    bytes.Buffer
+	indent string
+	// seen are the tables being encoded, to detect cycles
+	seen map[*lua.LTable]bool
+}
+
+func jsonEncode(l *lua.LState) int {
+	v := l.Get(1)
+	enc := &jsonEncoder{l: l, seen: map[*lua.LTable]bool{}}
+	if tbl := checkJSONOptions(l, 2); tbl != nil {
+		if lua.LVAsBool(tbl.RawGetString("pretty")) {
+			enc.indent = "  "
+		}
+		if indent, ok := tbl.RawGetString("indent").(lua.LString); ok && enc.indent != "" {
+			enc.indent = string(indent)
+		}
+	}
+	if err := enc.encode(v, 0); err != nil {
+		return etsError(l, ErrorScript, "json.encode", err)
+	}
+	l.Push(lua.LString(enc.buf.String()))
+	return 1
+}
+
+// newline starts a new line with the indentation of depth if the output is
+// pretty printed.
+func (enc *jsonEncoder) newline(depth int) {
+	if enc.indent != "" {
+		enc.buf.WriteByte('\n')
+		enc.buf.WriteString(strings.Repeat(enc.indent, depth))
+	}
+}
+
+func (enc *jsonEncoder) encode(v lua.LValue, depth int) error {
+	switch t := v.(type) {
+	case *lua.LNilType:
+		enc.buf.WriteString("null")
+	case lua.LBool:
+		enc.buf.WriteString(strconv.FormatBool(bool(t)))
+	case lua.LNumber:
+		f := float64(t)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("the number %s can't be encoded", t.String())
+		}
+		// as in JavaScript, exponents only for very large and small numbers
+		format := byte('f')
+		if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+			format = 'e'
+		}
+		enc.buf.WriteString(strconv.FormatFloat(f, format, -1, 64))
+	case lua.LString:
+		enc.encodeString(string(t))
+	case *lua.LUserData:
+		if _, ok := t.Value.(jsonNull); !ok {
+			return errors.New("a userdata value can't be encoded")
+		}
+		enc.buf.WriteString("null")
+	case *lua.LTable:
+		return enc.encodeTable(t, depth)
+	default:
+		return fmt.Errorf("a %s value can't be encoded", v.Type())
+	}
+	return nil
+}
+
+func (enc *jsonEncoder) encodeString(s string) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	// strings are always encoded
+	e.Encode(s)
+	enc.buf.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func (enc *jsonEncoder) encodeTable(tbl *lua.LTable, depth int) error {
+	if enc.seen[tbl] {
+		return errors.New("a table that contains itself can't be encoded")
+	}
+	enc.seen[tbl] = true
+	defer delete(enc.seen, tbl)
+	keys, isArray, err := jsonTableKeys(enc.l, tbl)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		if jsonObjectKeys(enc.l, tbl) != nil {
+			enc.buf.WriteString("{}")
+		} else {
+			enc.buf.WriteString("[]")
+		}
+		return nil
+	}
+	start, end := byte('{'), byte('}')
+	if isArray {
+		start, end = '[', ']'
+	}
+	enc.buf.WriteByte(start)
+	for i, key := range keys {
+		if i > 0 {
+			enc.buf.WriteByte(',')
+		}
+		enc.newline(depth + 1)
+		if !isArray {
+			enc.encodeString(key.String())
+			enc.buf.WriteByte(':')
+			if enc.indent != "" {
+				enc.buf.WriteByte(' ')
+			}
+		}
+		if err := enc.encode(tbl.RawGet(key), depth+1); err != nil {
+			return err
+		}
+	}
+	enc.newline(depth)
+	enc.buf.WriteByte(end)
+	return nil
+}
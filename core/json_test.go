@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		decode string
+		encode string
+		want   string
+	}{
+		{in: `{"b":1,"a":[true,false,"x"]}`, want: `{"a":[true,false,"x"],"b":1}`},
+		{in: `{"b":1,"a":2,"c":3}`, decode: "{ordered = true}", want: `{"b":1,"a":2,"c":3}`},
+		{in: `{"x":{"z":1,"y":{}}}`, decode: "{ordered = true}", want: `{"x":{"z":1,"y":{}}}`},
+		{in: `[1,null,3]`, decode: "{null = true}", want: `[1,null,3]`},
+		{in: `{"a":null,"b":1}`, want: `{"b":1}`},
+		{in: `{"a":null,"b":1}`, decode: "{null = true}", want: `{"a":null,"b":1}`},
+		{in: `[[],{}]`, want: `[[],[]]`},
+		{in: `[123456789012,1.5,-0.25,1e21,1e-7,0]`, want: `[123456789012,1.5,-0.25,1e+21,1e-07,0]`},
+		{in: `"<a & \"b\">ä"`, want: `"<a & \"b\">ä"`},
+		{in: `{"a":[1,2],"b":{}}`, encode: "{pretty = true}", want: "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": []\n}"},
+		{in: `[1]`, encode: `{pretty = true, indent = "\t"}`, want: "[\n\t1\n]"},
+		{in: ` false `, want: `false`},
+	} {
+		if tc.decode == "" {
+			tc.decode = "nil"
+		}
+		if tc.encode == "" {
+			tc.encode = "nil"
+		}
+		src := fmt.Sprintf(`
+local v, err = json.decode(%q, %s)
+assert(not err, err)
+local s = assert(json.encode(v, %s))
+assert(s == %q, s)
+`, tc.in, tc.decode, tc.encode, tc.want)
+		if err := runLua(t, src); err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		call string
+		want string
+	}{
+		{"function", `json.encode({f = print})`, "a function value can't be encoded"},
+		{"cycle", `local t = {} t[1] = t return json.encode(t)`, "a table that contains itself can't be encoded"},
+		{"NaN", `json.encode(0/0)`, "can't be encoded"},
+		{"infinity", `json.encode({1/0})`, "can't be encoded"},
+		{"userdata", `json.encode(document.new())`, "a userdata value can't be encoded"},
+		{"table key", `json.encode({[true] = 1})`, "a table key of type boolean can't be encoded"},
+		{"syntax", `json.decode("{\"a\":}")`, "missing value after object key at byte offset 4"},
+		{"end of input", `json.decode("[1,2")`, "unexpected end of JSON input"},
+		{"empty", `json.decode("")`, "unexpected end of JSON input"},
+		{"trailing data", `json.decode("[1] [2]")`, "data after the JSON value"},
+	} {
+		call := tc.call
+		if call[:5] == "json." {
+			call = "return " + call
+		}
+		src := fmt.Sprintf(`
+local ok, err = (function() %s end)()
+assert(ok == false, "no error")
+assert(string.find(err, %q, 1, true), err)
+`, call, tc.want)
+		if err := runLua(t, src); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestJSONDecodeFile(t *testing.T) {
+	dir := t.TempDir()
+	for fn, data := range map[string]string{
+		"array.json":  `[{"n":1},{"n":2},{"n":3}]`,
+		"object.json": `{"b":1,"a":2}`,
+		"broken.json": `[{"n":1},{"n":]`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		src  string
+	}{
+		{"value", `
+local v = assert(json.decodefile("object.json"))
+assert(v.a == 2 and v.b == 1)`},
+		{"ordered", `
+local v = assert(json.decodefile("object.json", {ordered = true}))
+assert(json.encode(v) == '{"b":1,"a":2}', json.encode(v))`},
+		{"each", `
+local sum = 0
+local n = assert(json.decodefile("array.json", {each = function(v, i) sum = sum + v.n * i end}))
+assert(n == 3, n)
+assert(sum == 14, sum)`},
+		{"each stops", `
+local seen = 0
+local n = assert(json.decodefile("array.json", {each = function(v, i) seen = i return i < 2 end}))
+assert(n == 2, n)
+assert(seen == 2, seen)`},
+		{"each without array", `
+local ok, err = json.decodefile("object.json", {each = function() end})
+assert(ok == false)
+assert(string.find(err, "each needs a JSON array", 1, true), err)`},
+		{"syntax error", `
+local ok, err = json.decodefile("broken.json")
+assert(ok == false)
+assert(string.find(err, "broken.json: invalid character ']'", 1, true), err)`},
+		{"syntax error with each", `
+local n = 0
+local ok, err = json.decodefile("broken.json", {each = function() n = n + 1 end})
+assert(ok == false)
+assert(n == 1, n)
+assert(string.find(err, "broken.json", 1, true), err)`},
+		{"missing file", `
+local ok, err = json.decodefile("missing.json")
+assert(ok == false)
+assert(string.find(err, "missing.json", 1, true), err)`},
+	} {
+		s := &Session{
+			Luafile:   filepath.Join(dir, "test.lua"),
+			Source:    tc.src,
+			Dir:       dir,
+			Logger:    zap.NewNop().Sugar(),
+			CatchExit: true,
+		}
+		if err := s.Run(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
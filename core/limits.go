@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// errOutputSize is returned by a limitWriter if the output size limit is
+// exceeded.
+var errOutputSize = errors.New("output size limit exceeded")
+
+// limitWriter counts the bytes written to w and fails with errOutputSize if
+// max (if greater than 0) is exceeded.
+type limitWriter struct {
+	w        io.WriteCloser
+	n        int64
+	max      int64
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.max > 0 && lw.n+int64(len(p)) > lw.max {
+		lw.exceeded = true
+		return 0, errOutputSize
+	}
+	n, err := lw.w.Write(p)
+	lw.n += int64(n)
+	return n, err
+}
+
+func (lw *limitWriter) Close() error {
+	return lw.w.Close()
+}
+
+// limitError stops the script with an error message that tells which limit
+// was exceeded.
+func limitError(l *lua.LState, function string, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	sessionFromState(l).lastError = &Error{Kind: ErrorLimit, Function: function, Message: msg}
+	l.RaiseError(msg)
+}
+
+// checkOutputSize stops the script if the PDF file of the document is larger
+// than the output size limit of the session. The incomplete PDF file is
+// removed.
+func checkOutputSize(l *lua.LState, d *doc, function string) {
+	if d.w.exceeded {
+		d.w.Close()
+		if d.tofile {
+			os.Remove(d.d.Filename)
+		}
+		limitError(l, function, "the PDF file exceeds the limit of %d bytes", d.w.max)
+	}
+}
+
+// newContext returns the context for the Lua state with the timeout of the
+// session.
+func (s *Session) newContext() (context.Context, context.CancelFunc) {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s.Timeout > 0 {
+		return context.WithTimeout(ctx, s.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// contextError changes the error if the script was stopped by the context of
+// the Lua state, for example because of the timeout.
+func (s *Session) contextError(err *Error) {
+	if s.ctx == nil || s.ctx.Err() == nil || !strings.Contains(err.Message, s.ctx.Err().Error()) {
+		return
+	}
+	switch {
+	case errors.Is(s.ctx.Err(), context.DeadlineExceeded) && s.Timeout > 0:
+		err.Message = fmt.Sprintf("the script exceeds the time limit of %s", s.Timeout)
+	default:
+		err.Message = fmt.Sprintf("the script was stopped: %s", s.ctx.Err())
+	}
+	err.Kind = ErrorLimit
+	err.Function = ""
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOutputSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name      string
+		max       int64
+		wantLimit bool
+	}{
+		{"below the limit", 1 << 20, false},
+		{"above the limit", 100, true},
+	} {
+		s := &Session{
+			Luafile: "test.lua",
+			Source: `local d = document.new("out.pdf")
+d.newpage()
+d.currentpage().shipout()
+d.finish()`,
+			Dir:           dir,
+			Logger:        zap.NewNop().Sugar(),
+			MaxOutputSize: tc.max,
+		}
+		err := s.Run()
+		pdf := filepath.Join(dir, "out.pdf")
+		_, statErr := os.Stat(pdf)
+		if !tc.wantLimit {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			if statErr != nil {
+				t.Errorf("%s: %v", tc.name, statErr)
+			}
+			os.Remove(pdf)
+			continue
+		}
+		var etsErr *Error
+		if !errors.As(err, &etsErr) || etsErr.Kind != ErrorLimit {
+			t.Errorf("%s: got error %v, want a limit error", tc.name, err)
+		}
+		if !os.IsNotExist(statErr) {
+			t.Errorf("%s: the incomplete PDF file was not removed", tc.name)
+		}
+	}
+}
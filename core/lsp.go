@@ -0,0 +1,530 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// The language server speaks the language server protocol (LSP) with JSON-RPC
+// messages. Only full text synchronization is supported. The analysis is the
+// same as in Check and uses the binding metadata from API.
+
+// LSP error codes and constants
+const (
+	lspMethodNotFound     = -32601
+	lspInvalidRequest     = -32600
+	lspSyncFull           = 1
+	lspSeverityError      = 1
+	lspSeverityWarning    = 2
+	lspCompletionFunction = 3
+	lspCompletionMethod   = 2
+	lspCompletionField    = 5
+)
+
+type lspRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   lspError        `json:"error"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspCompletionItem struct {
+	Label         string    `json:"label"`
+	Kind          int       `json:"kind"`
+	Detail        string    `json:"detail,omitempty"`
+	Documentation lspMarkup `json:"documentation"`
+}
+
+type lspTextDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument lspTextDocumentItem `json:"textDocument"`
+	Position     lspPosition         `json:"position"`
+}
+
+type lspDidChange struct {
+	TextDocument   lspTextDocumentItem `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspServer struct {
+	w *bufio.Writer
+	// docs has the text of the open documents by URI
+	docs map[string]string
+}
+
+// ServeLSP runs a language server for ets Lua scripts. It reads the messages
+// from r and writes the responses to w until the client sends the exit
+// notification. The server publishes the problems found by Check as
+// diagnostics and provides completion and hover information for the ets
+// libraries, objects and nodes.
+func ServeLSP(r io.Reader, w io.Writer) error {
+	s := &lspServer{
+		w:    bufio.NewWriter(w),
+		docs: map[string]string{},
+	}
+	br := bufio.NewReader(r)
+	for {
+		data, err := readLSPMessage(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var req lspRequest
+		if err = json.Unmarshal(data, &req); err != nil {
+			if err = s.sendError(nil, lspInvalidRequest, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err = s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// readLSPMessage reads the header and the content of a message.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *lspServer) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(data))
+	s.w.Write(data)
+	return s.w.Flush()
+}
+
+func (s *lspServer) send(id json.RawMessage, result interface{}) error {
+	return s.write(lspResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *lspServer) sendError(id json.RawMessage, code int, msg string) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return s.write(lspErrorResponse{JSONRPC: "2.0", ID: id, Error: lspError{Code: code, Message: msg}})
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	return s.write(lspNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle answers a request or processes a notification (a message without
+// id).
+func (s *lspServer) handle(req lspRequest) error {
+	switch req.Method {
+	case "initialize":
+		return s.send(req.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   lspSyncFull,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "ets"},
+		})
+	case "shutdown":
+		return s.send(req.ID, nil)
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocumentItem `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		s.docs[params.TextDocument.URI] = params.TextDocument.Text
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params lspDidChange
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		s.docs[params.TextDocument.URI] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocumentItem `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         params.TextDocument.URI,
+			"diagnostics": []lspDiagnostic{},
+		})
+	case "textDocument/completion":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.sendError(req.ID, lspInvalidRequest, err.Error())
+		}
+		return s.send(req.ID, s.completion(params.TextDocument.URI, params.Position))
+	case "textDocument/hover":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.sendError(req.ID, lspInvalidRequest, err.Error())
+		}
+		if hover := s.hover(params.TextDocument.URI, params.Position); hover != "" {
+			return s.send(req.ID, map[string]interface{}{"contents": lspMarkup{Kind: "markdown", Value: hover}})
+		}
+		return s.send(req.ID, nil)
+	}
+	if req.ID != nil {
+		return s.sendError(req.ID, lspMethodNotFound, "method not supported: "+req.Method)
+	}
+	return nil
+}
+
+// uriFilename returns the file name of a file URI for messages.
+func uriFilename(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// utf16Column returns the position of the byte index in the line in UTF-16
+// code units as used by LSP.
+func utf16Column(line string, index int) int {
+	if index > len(line) {
+		index = len(line)
+	}
+	col := 0
+	for _, r := range line[:index] {
+		col += len(utf16.Encode([]rune{r}))
+	}
+	return col
+}
+
+// byteIndex returns the byte index of the position in UTF-16 code units.
+func byteIndex(line string, col int) int {
+	n := 0
+	for i, r := range line {
+		if n >= col {
+			return i
+		}
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+// lineRange returns the range of the identifier at the byte index in the
+// line or of the whole line (without indentation) if index is negative.
+func lineRange(lines []string, line int, index int) lspRange {
+	if line < 0 || line >= len(lines) {
+		return lspRange{}
+	}
+	text := strings.TrimRight(lines[line], "\r")
+	start, end := index, index
+	if index < 0 {
+		start = len(text) - len(strings.TrimLeft(text, " \t"))
+		end = len(text)
+	} else {
+		for end < len(text) && isIdentifierByte(text[end]) {
+			end++
+		}
+		if end == start && end < len(text) {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+	}
+	return lspRange{
+		Start: lspPosition{Line: line, Character: utf16Column(text, start)},
+		End:   lspPosition{Line: line, Character: utf16Column(text, end)},
+	}
+}
+
+// diagnostics returns the syntax error or the problems found by the checker.
+func diagnostics(filename string, text string) []lspDiagnostic {
+	lines := strings.Split(text, "\n")
+	diags := []lspDiagnostic{}
+	c, err := checkSource(text, filename, 0)
+	if err != nil {
+		var perr *parse.Error
+		if !errors.As(err, &perr) {
+			return diags
+		}
+		line, index := perr.Pos.Line-1, perr.Pos.Column-1
+		if perr.Pos.Line == parse.EOF {
+			// the last line that is not empty
+			line, index = len(lines)-1, -1
+			for line > 0 && strings.TrimSpace(lines[line]) == "" {
+				line--
+			}
+		}
+		msg := perr.Message
+		if perr.Token != "" {
+			msg = fmt.Sprintf("%s near '%s'", msg, perr.Token)
+		}
+		return append(diags, lspDiagnostic{
+			Range:    lineRange(lines, line, index),
+			Severity: lspSeverityError,
+			Source:   "ets",
+			Message:  msg,
+		})
+	}
+	for _, cm := range c.messages {
+		diags = append(diags, lspDiagnostic{
+			Range:    lineRange(lines, cm.Line-1, cm.Column-1),
+			Severity: lspSeverityWarning,
+			Source:   "ets",
+			Message:  cm.Message,
+		})
+	}
+	return diags
+}
+
+func (s *lspServer) publishDiagnostics(uri string) error {
+	return s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics(uriFilename(uri), s.docs[uri]),
+	})
+}
+
+// kindAt returns the kind of the variable name at the beginning of the line
+// (starting at 1). If the text can not be parsed, for example because the
+// user is typing in the line, the line is left out.
+func kindAt(text string, line int, name string) string {
+	c, err := checkSource(text, "", line)
+	if err != nil {
+		lines := strings.Split(text, "\n")
+		if line <= len(lines) {
+			lines[line-1] = ""
+		}
+		c, err = checkSource(strings.Join(lines, "\n"), "", line)
+	}
+	if err == nil {
+		return c.envAt[name]
+	}
+	switch name {
+	case documentLibrary.name:
+		return documentLibrary.className
+	case nodeLibrary.name:
+		return nodeLibrary.className
+	case jsonLibrary.name:
+		return jsonLibrary.className
+	}
+	return kindUnknown
+}
+
+// memberAccessRE matches an object and the beginning of a field name at the
+// end of the text before the cursor.
+var memberAccessRE = regexp.MustCompile(`([A-Za-z_]\w*)\s*[.:]\s*(\w*)$`)
+
+// completion returns the functions and fields of the object before the dot.
+func (s *lspServer) completion(uri string, pos lspPosition) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	text := s.docs[uri]
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return items
+	}
+	line := lines[pos.Line]
+	m := memberAccessRE.FindStringSubmatch(line[:byteIndex(line, pos.Character)])
+	if m == nil {
+		return items
+	}
+	kind := kindAt(text, pos.Line+1, m[1])
+	if kind == kindUnknown {
+		return items
+	}
+	functions, fields := members(kind, false)
+	itemKind := lspCompletionMethod
+	if cls := apiClass(kind); cls != nil && cls.Global != "" {
+		itemKind = lspCompletionFunction
+	}
+	for _, fn := range functions {
+		items = append(items, lspCompletionItem{
+			Label:         fn.Name,
+			Kind:          itemKind,
+			Detail:        signature(qualifiedName(kind, fn.Name), fn),
+			Documentation: lspMarkup{Kind: "markdown", Value: functionDoc(fn)},
+		})
+	}
+	for _, f := range fields {
+		items = append(items, lspCompletionItem{
+			Label:         f.Name,
+			Kind:          lspCompletionField,
+			Detail:        f.Type,
+			Documentation: lspMarkup{Kind: "markdown", Value: f.Description + accessNote(f)},
+		})
+	}
+	return items
+}
+
+// hover returns the documentation of the function, the field or the
+// variable at the position in Markdown or the empty string.
+func (s *lspServer) hover(uri string, pos lspPosition) string {
+	text := s.docs[uri]
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+	start := byteIndex(line, pos.Character)
+	end := start
+	for start > 0 && isIdentifierByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isIdentifierByte(line[end]) {
+		end++
+	}
+	if start == end {
+		return ""
+	}
+	name := line[start:end]
+	if m := memberAccessRE.FindStringSubmatch(line[:end]); m != nil && m[2] == name {
+		kind := kindAt(text, pos.Line+1, m[1])
+		if fn := function(kind, name); fn != nil {
+			return fmt.Sprintf("```lua\n%s\n```\n\n%s", signature(qualifiedName(kind, name), *fn), functionDoc(*fn))
+		}
+		for _, write := range []bool{false, true} {
+			_, fields := members(kind, write)
+			for _, f := range fields {
+				if f.Name == name {
+					return fmt.Sprintf("```lua\n(field) %s.%s: %s\n```\n\n%s%s", kind, name, f.Type, f.Description, accessNote(f))
+				}
+			}
+		}
+		return ""
+	}
+	kind := kindAt(text, pos.Line+1, name)
+	if kind == kindUnknown {
+		// the variable may be declared in this line
+		kind = kindAt(text, pos.Line+2, name)
+	}
+	if cls := apiClass(kind); cls != nil {
+		return fmt.Sprintf("```lua\n%s: %s\n```\n\n%s", name, cls.Name, cls.Description)
+	}
+	return ""
+}
+
+// signature returns the function signature in the notation of the Lua
+// language server.
+func signature(qname string, fn Function) string {
+	var args, returns []string
+	for _, arg := range fn.Args {
+		name := arg.Name
+		if arg.Optional {
+			name += "?"
+		}
+		args = append(args, name+": "+arg.Type)
+	}
+	for _, ret := range fn.Returns {
+		typ := ret.Type
+		if ret.Optional {
+			typ += "?"
+		}
+		returns = append(returns, typ)
+	}
+	sig := fmt.Sprintf("function %s(%s)", qname, strings.Join(args, ", "))
+	if len(returns) > 0 {
+		sig += ": " + strings.Join(returns, ", ")
+	}
+	return sig
+}
+
+// functionDoc returns the description of the function with its arguments and
+// return values in Markdown.
+func functionDoc(fn Function) string {
+	var sb strings.Builder
+	sb.WriteString(fn.Description)
+	for _, arg := range fn.Args {
+		fmt.Fprintf(&sb, "\n\n@*param* `%s` — %s", arg.Name, arg.Description)
+	}
+	for _, ret := range fn.Returns {
+		fmt.Fprintf(&sb, "\n\n@*return* `%s` — %s", ret.Name, ret.Description)
+	}
+	return sb.String()
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLSPMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "message", input: "Content-Length: 2\r\n\r\n{}", want: []string{"{}"}},
+		{name: "two messages", input: "Content-Length: 2\r\n\r\n{}Content-Length: 4\r\n\r\n[1,2]", want: []string{"{}", "[1,2"}},
+		{name: "other headers", input: "content-length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}", want: []string{"{}"}},
+		{name: "UTF-8 content", input: "Content-Length: 8\r\n\r\n\"ä😀\"", want: []string{`"ä😀"`}},
+		{name: "without Content-Length", input: "Content-Type: x\r\n\r\n{}", wantErr: true},
+		{name: "invalid Content-Length", input: "Content-Length: x\r\n\r\n{}", wantErr: true},
+		{name: "content too short", input: "Content-Length: 10\r\n\r\n{}", wantErr: true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tc.input))
+		var got []string
+		var err error
+		for len(got) < len(tc.want) || tc.wantErr {
+			var data []byte
+			if data, err = readLSPMessage(r); err != nil {
+				break
+			}
+			got = append(got, string(data))
+		}
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error %v, want error %t", tc.name, err, tc.wantErr)
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		want []lspDiagnostic
+	}{
+		{
+			name: "no problems",
+			text: "local g = node.new(\"glyph\")\ng.width = 1\n",
+			want: []lspDiagnostic{},
+		},
+		{
+			name: "UTF-16 columns",
+			text: "local g = node.new(\"glyph\")\nlocal s = \"ä😀\" g.heigth = 1\n",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 1, Character: 18}, End: lspPosition{Line: 1, Character: 24}},
+				Severity: lspSeverityWarning,
+				Source:   "ets",
+				Message:  `unknown field "heigth" in glyph nodes (set)`,
+			}},
+		},
+		{
+			name: "syntax error",
+			text: "local x = \"ä😀\" + + 1\n",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 0, Character: 18}, End: lspPosition{Line: 0, Character: 19}},
+				Severity: lspSeverityError,
+				Source:   "ets",
+				Message:  "syntax error near '+'",
+			}},
+		},
+		{
+			name: "syntax error at the end of the file",
+			text: "local d = document.new(\"out.pdf\")\n  local x =\n\n",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 1, Character: 2}, End: lspPosition{Line: 1, Character: 11}},
+				Severity: lspSeverityError,
+				Source:   "ets",
+				Message:  "syntax error near '='",
+			}},
+		},
+	} {
+		got := diagnostics("test.lua", tc.text)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	s := &lspServer{docs: map[string]string{
+		"lib":  "local d = document.",
+		"node": "local g = node.new(\"glyph\")\ng.w\nlocal x = 1",
+		"doc":  "local d = document.new(\"out.pdf\")\nd.",
+		"none": "local t = {}\nt.",
+	}}
+	for _, tc := range []struct {
+		uri   string
+		pos   lspPosition
+		label string
+		kind  int
+		// detail of the item label
+		detail string
+	}{
+		{"lib", lspPosition{Line: 0, Character: 19}, "new", lspCompletionFunction, "function document.new(filename?: string): doc|false, string?"},
+		{"node", lspPosition{Line: 1, Character: 3}, "width", lspCompletionField, "number"},
+		{"node", lspPosition{Line: 1, Character: 2}, "codepoint", lspCompletionField, "number"},
+		{"doc", lspPosition{Line: 1, Character: 2}, "newpage", lspCompletionMethod, "function doc.newpage(): page"},
+		{"none", lspPosition{Line: 1, Character: 2}, "", 0, ""},
+	} {
+		items := s.completion(tc.uri, tc.pos)
+		var found *lspCompletionItem
+		for i := range items {
+			if items[i].Label == tc.label {
+				found = &items[i]
+			}
+		}
+		switch {
+		case tc.label == "" && len(items) > 0:
+			t.Errorf("%s: got %d items, want none", tc.uri, len(items))
+		case tc.label == "":
+		case found == nil:
+			t.Errorf("%s: %s is missing", tc.uri, tc.label)
+		case found.Kind != tc.kind || found.Detail != tc.detail:
+			t.Errorf("%s: got %s kind %d %q, want kind %d %q", tc.uri, tc.label, found.Kind, found.Detail, tc.kind, tc.detail)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	text := `local d = document.new("out.pdf")
+d.newpage()
+local g = node.new("glyph")
+g.width = 2
+local t = {}`
+	s := &lspServer{docs: map[string]string{"test": text}}
+	for _, tc := range []struct {
+		pos  lspPosition
+		want string
+	}{
+		{lspPosition{Line: 0, Character: 20}, "```lua\nfunction document.new(filename?: string): doc|false, string?\n```"},
+		{lspPosition{Line: 0, Character: 6}, "```lua\nd: doc\n```"},
+		{lspPosition{Line: 1, Character: 4}, "```lua\nfunction doc.newpage(): page\n```"},
+		{lspPosition{Line: 3, Character: 3}, "```lua\n(field) glyphnode.width: number\n```\n\nThe advance width of the glyph."},
+		{lspPosition{Line: 3, Character: 0}, "```lua\ng: glyphnode\n```"},
+		{lspPosition{Line: 4, Character: 6}, ""},
+		{lspPosition{Line: 9, Character: 0}, ""},
+	} {
+		got := s.hover("test", tc.pos)
+		if !strings.HasPrefix(got, tc.want) || (tc.want == "") != (got == "") {
+			t.Errorf("hover at %d:%d: got %q, want %q", tc.pos.Line, tc.pos.Character, got, tc.want)
+		}
+	}
+}
+
+// lspMessage returns the message with the LSP header.
+func lspMessage(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func TestServeLSP(t *testing.T) {
+	input := lspMessage(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{}}) +
+		lspMessage(map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": "file:///test.lua", "text": "local g = node.new(\"glyph\")\ng.heigth = 1\n"},
+		}}) +
+		lspMessage(map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "unknown"}) +
+		lspMessage(map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	var out bytes.Buffer
+	if err := ServeLSP(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(&out)
+	for i, want := range []string{
+		`"id":1,"result":{"capabilities"`,
+		`"method":"textDocument/publishDiagnostics","params":{"diagnostics":[{"range":{"start":{"line":1,"character":2}`,
+		`"id":2,"error":{"code":-32601,"message":"method not supported: unknown"}`,
+	} {
+		data, err := readLSPMessage(r)
+		if err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("message %d: got %s, want %s", i+1, data, want)
+		}
+	}
+	if _, err := readLSPMessage(r); err == nil {
+		t.Error("more messages than expected")
+	}
+}
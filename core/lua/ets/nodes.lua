@@ -0,0 +1,112 @@
+-- ets.nodes: helper functions to create node lists.
+--
+--     local nodes = require("ets.nodes")
+--     local head, tail = nodes.fromtext(fnt, "Hello world")
+
+local M = {}
+
+---Create a glue node.
+---@param width number The natural width.
+---@param stretch? number The allowed stretch (default 0).
+---@param shrink? number The allowed shrink (default 0).
+---@return gluenode glue
+function M.glue(width, stretch, shrink)
+    local g = node.new("glue")
+    g.width = width
+    g.stretch = stretch or 0
+    g.shrink = shrink or 0
+    return g
+end
+
+---Create a glue node with the interword space of the font.
+---@param fnt font
+---@return gluenode glue
+function M.spaceglue(fnt)
+    return M.glue(fnt.space, fnt.stretch, fnt.shrink)
+end
+
+---Create a penalty node.
+---@param value number The penalty, 10000 forbids and -10000 forces a line break.
+---@return penaltynode penalty
+function M.penalty(value)
+    local p = node.new("penalty")
+    p.penalty = value
+    return p
+end
+
+---Create a glyph node from an entry of the table returned by fnt.shape().
+---@param glyph table
+---@return glyphnode glyph
+function M.glyph(glyph)
+    local g = node.new("glyph")
+    g.width = glyph.advance
+    g.codepoint = glyph.codepoint
+    g.components = glyph.components
+    g.font = glyph.font
+    g.hyphenate = glyph.hyphenate
+    return g
+end
+
+---Create a language node that switches the language for hyphenation.
+---@param lang lang The language from d.loadpattern().
+---@return langnode langnode
+function M.lang(lang)
+    local n = node.new("lang")
+    n.lang = lang
+    return n
+end
+
+---Append the node n to the list. head and tail may be nil for an empty list.
+---@param head node?
+---@param tail node?
+---@param n node
+---@return node head
+---@return node tail
+function M.append(head, tail, n)
+    head = node.insertafter(head, tail, n)
+    while n.next do
+        n = n.next
+    end
+    return head, n
+end
+
+---Return the last node of the list.
+---@param head node
+---@return node tail
+function M.tail(head)
+    local n = head
+    while n.next do
+        n = n.next
+    end
+    return n
+end
+
+---Shape the text with the font and append glyph and glue nodes to the list.
+---head and tail may be omitted to start a new list.
+---@param fnt font
+---@param text string
+---@param head? node
+---@param tail? node
+---@return node? head
+---@return node? tail
+function M.fromtext(fnt, text, head, tail)
+    for _, glyph in ipairs(fnt.shape(text)) do
+        if glyph.isspace then
+            head, tail = M.append(head, tail, M.spaceglue(fnt))
+        else
+            head, tail = M.append(head, tail, M.glyph(glyph))
+        end
+    end
+    return head, tail
+end
+
+---Put the node list into a vertical list.
+---@param head node
+---@return vlistnode vlist
+function M.vpack(head)
+    local vl = node.new("vlist")
+    vl.list = head
+    return vl
+end
+
+return M
@@ -0,0 +1,84 @@
+-- ets.paragraph: build paragraphs from text and break them into lines.
+--
+--     local paragraph = require("ets.paragraph")
+--     local p = paragraph.new(d, { font = fnt })
+--     p.add("Hello ")
+--     p.add("world", boldfont)
+--     local vl = p.linebreak({ hsize = document.sp("10cm") })
+--     d.outputat(document.sp("2cm"), document.sp("27cm"), vl)
+
+local nodes = require("ets.nodes")
+
+local M = {}
+
+---Create a paragraph of the document d. The options are font (the default
+---font of add()) and lang (the language for hyphenation, the default
+---language of the document if omitted).
+---@param d doc
+---@param options? table
+---@return table paragraph
+function M.new(d, options)
+    options = options or {}
+    local p = {}
+    local head, tail
+    if options.lang then
+        head = nodes.lang(options.lang)
+        tail = head
+    end
+
+    ---Append the text in the font (the font of the paragraph if omitted).
+    ---@param text string
+    ---@param fnt? font
+    function p.add(text, fnt)
+        fnt = fnt or options.font
+        if not fnt then
+            error("ets.paragraph: no font for the text", 2)
+        end
+        head, tail = nodes.fromtext(fnt, text, head, tail)
+    end
+
+    ---Append a node list.
+    ---@param n node
+    function p.addnodes(n)
+        head, tail = nodes.append(head, tail, n)
+    end
+
+    ---Hyphenate the paragraph and break it into lines. The parameters are
+    ---hsize (required) and lineheight (default 1.2 times the font size).
+    ---After the line break the paragraph is empty.
+    ---@param param table
+    ---@return vlistnode vlist
+    function p.linebreak(param)
+        if not head then
+            error("ets.paragraph: the paragraph is empty", 2)
+        end
+        local lineheight = param.lineheight
+        if not lineheight and options.font then
+            lineheight = options.font.size * 1.2
+        end
+        d.hyphenate(head)
+        node.append_lineend(tail)
+        local vl = node.linebreak(head, { hsize = param.hsize, lineheight = lineheight })
+        head, tail = nil, nil
+        return vl
+    end
+
+    return p
+end
+
+---Typeset the text in the font and break it into lines of the width hsize.
+---The optional parameters are lang and lineheight.
+---@param d doc
+---@param fnt font
+---@param text string
+---@param hsize number
+---@param param? table
+---@return vlistnode vlist
+function M.typeset(d, fnt, text, hsize, param)
+    param = param or {}
+    local p = M.new(d, { font = fnt, lang = param.lang })
+    p.add(text)
+    return p.linebreak({ hsize = hsize, lineheight = param.lineheight })
+end
+
+return M
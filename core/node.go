@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/speedata/boxesandglue/backend/bag"
 	bagnode "github.com/speedata/boxesandglue/backend/node"
@@ -33,20 +34,219 @@ func checkNode(l *lua.LState, argpos int) bagnode.Node {
 	return nil
 }
 
-type node struct {
+// A nodeType describes a kind of node that can be created with node.new().
+type nodeType struct {
+	// name is the name used in node.new()
+	name   string
+	class  *luaClass
+	create func() bagnode.Node
+}
+
+// nodeTypes contains all node types by their name in node.new().
+var nodeTypes = map[string]*nodeType{}
+
+// nodeTypesByGoType contains all node types by the Go type of the node.
+var nodeTypesByGoType = map[reflect.Type]*nodeType{}
+
+// nodeListArg is the argument of functions that take a node list.
+var nodeListArg = Value{Name: "nodelist", Type: "node", Description: "The head of the node list."}
+
+// nodeLibrary is the global table node.
+var nodeLibrary = &luaLibrary{
+	name:        luaNodeTypeName,
+	className:   "nodelib",
+	description: "The node library creates and manipulates nodes, the smallest units of the typesetting software.",
+	functions: []*luaFunction{
+		{Function{
+			Name:        "new",
+			Args:        []Value{{Name: "type", Type: "string", Description: "The node type such as `glyph` or `glue`."}},
+			Returns:     []Value{{Name: "n", Type: "node", Description: "The new node."}},
+			Description: "Create a new node of the given type.",
+		}, newNode},
+		{Function{
+			Name:        "append_lineend",
+			Args:        []Value{nodeListArg},
+			Description: "Append a penalty (10000), an infinite stretchable glue and a penalty of -10000 at the end of the node list. Used to finish a paragraph.",
+		}, nodeAppendLineEndAfter},
+		{Function{
+			Name:        "debug",
+			Args:        []Value{nodeListArg},
+			Description: "Show the node structure on the standard output.",
+		}, debugNode},
+		{Function{
+			Name:        "hpack",
+			Args:        []Value{nodeListArg},
+			Returns:     []Value{{Name: "hlist", Type: "hlistnode", Description: "The horizontal list."}},
+			Description: "Put the node list in the `list` field of a newly created `hlist` node.",
+		}, nodeHpack},
+		{Function{
+			Name: "insertafter",
+			Args: []Value{
+				{Name: "head", Type: "node?", Description: "The head of the node list."},
+				{Name: "cur", Type: "node?", Description: "The node after which the new node is inserted."},
+				{Name: "newnode", Type: "node", Description: "The node to insert."},
+			},
+			Returns:     []Value{{Name: "head", Type: "node", Description: "The head of the node list."}},
+			Description: "Insert newnode after cur in the list starting with head.",
+		}, nodeInsertAfter},
+		{Function{
+			Name: "insertbefore",
+			Args: []Value{
+				{Name: "head", Type: "node?", Description: "The head of the node list."},
+				{Name: "cur", Type: "node?", Description: "The node before which the new node is inserted."},
+				{Name: "newnode", Type: "node", Description: "The node to insert."},
+			},
+			Returns:     []Value{{Name: "head", Type: "node", Description: "The new head of the node list."}},
+			Description: "Insert newnode before cur in the list starting with head.",
+		}, nodeInsertBefore},
+		{Function{
+			Name: "linebreak",
+			Args: []Value{
+				nodeListArg,
+				{Name: "parameter", Type: "table", Description: "The fields `hsize` (the width of the lines) and `lineheight` (the distance of the lines) in scaled points."},
+			},
+			Returns:     []Value{{Name: "vlist", Type: "vlistnode", Description: "The lines."}},
+			Description: "Break the node list into lines.",
+		}, nodeLinebreak},
+	},
+}
+
+// nodeClass has the fields common to all nodes.
+var nodeClass = &luaClass{
+	name:        luaNodeTypeName,
+	description: "The fields common to all nodes.",
+	fields: []*luaField{
+		{Value{Name: "next", Type: "node?", Description: "A link to the next node of the linked list. Possibly nil."}, nodeIndexNext, nodeNewIndexNext},
+		{Value{Name: "prev", Type: "node?", Description: "A link to the previous node of the linked list. Possibly nil."}, nodeIndexPrev, nodeNewIndexPrev},
+	},
+}
+
+// Value descriptions of fields that several node types have.
+var (
+	listField   = Value{Name: "list", Type: "node?", Description: "The contents of the list."}
+	widthField  = Value{Name: "width", Type: "number", Description: "The width of the list in scaled points."}
+	heightField = Value{Name: "height", Type: "number", Description: "The height of the list in scaled points."}
+	depthField  = Value{Name: "depth", Type: "number", Description: "The depth of the list in scaled points."}
+)
+
+func init() {
+	for _, nt := range []*nodeType{
+		{
+			name: "disc",
+			class: &luaClass{
+				name:        luaDiscNodeTypeName,
+				description: "A hyphenation point.",
+				fields: []*luaField{
+					{Value{Name: "pre", Type: "node?", Description: "The glyphs that appear at the end of a line during a line break."}, discIndexPre, discNewIndexPre},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewDisc() },
+		},
+		{
+			name: "glue",
+			class: &luaClass{
+				name:        luaGlueNodeTypeName,
+				description: "A stretchable and shrinkable space.",
+				fields: []*luaField{
+					{Value{Name: "width", Type: "number", Description: "The natural width of the glue."}, glueIndexWidth, glueNewIndexWidth},
+					{Value{Name: "stretch", Type: "number", Description: "The allowed stretch of the glue."}, glueIndexStretch, glueNewIndexStretch},
+					{Value{Name: "shrink", Type: "number", Description: "The allowed shrink width of the glue."}, glueIndexShrink, glueNewIndexShrink},
+					{Value{Name: "stretch_order", Type: "number", Description: "The infinity order of the stretchability. 0 = finite glue, 1–3: infinite glue."}, glueIndexStretchOrder, glueNewIndexStretchOrder},
+					{Value{Name: "shrink_order", Type: "number", Description: "The infinity order of the shrinkability. 0 = finite glue, 1–3: infinite glue."}, glueIndexShrinkOrder, glueNewIndexShrinkOrder},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewGlue() },
+		},
+		{
+			name: "glyph",
+			class: &luaClass{
+				name:        luaGlyphNodeTypeName,
+				description: "A single “letter” to be displayed. This can be anything the font can display.",
+				fields: []*luaField{
+					{Value{Name: "codepoint", Type: "number", Description: "The glyph id in the font."}, glyphIndexCodepoint, glyphNewIndexCodepoint},
+					{Value{Name: "components", Type: "string", Description: "The (unicode) characters that represent the glyph."}, glyphIndexComponents, glyphNewIndexComponents},
+					{Value{Name: "font", Type: "font", Description: "The font object which this glyph is part of."}, nil, glyphNewIndexFont},
+					{Value{Name: "hyphenate", Type: "boolean", Description: "This glyph is part of a hyphenatable word."}, nil, glyphNewIndexHyphenate},
+					{Value{Name: "width", Type: "number", Description: "The advance width of the glyph."}, glyphIndexWidth, glyphNewIndexWidth},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewGlyph() },
+		},
+		{
+			name: "hlist",
+			class: &luaClass{
+				name:        luaHlistNodeTypeName,
+				description: "A horizontal list.",
+				fields: []*luaField{
+					{listField, hlistIndexList, hlistNewIndexList},
+					{widthField, hlistIndexWidth, hlistNewIndexWidth},
+					{heightField, hlistIndexHeight, hlistNewIndexHeight},
+					{depthField, hlistIndexDepth, hlistNewIndexDepth},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewHList() },
+		},
+		{
+			name: "image",
+			class: &luaClass{
+				name:        luaImageNodeTypeName,
+				description: "An instance of an image file.",
+				fields: []*luaField{
+					{Value{Name: "img", Type: "image", Description: "The image object from `doc.createimage()`."}, nil, imageNodeNewIndexImg},
+					{Value{Name: "width", Type: "number", Description: "The desired image width."}, nil, imageNodeNewIndexWidth},
+					{Value{Name: "height", Type: "number", Description: "The desired image height."}, nil, imageNodeNewIndexHeight},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewImage() },
+		},
+		{
+			name: "lang",
+			class: &luaClass{
+				name:        luaLangNodeTypeName,
+				description: "A language node switches the language for hyphenation.",
+				fields: []*luaField{
+					{Value{Name: "lang", Type: "lang", Description: "The language object from `doc.loadpattern()`."}, nil, langNodeNewIndexLang},
+					{Value{Name: "name", Type: "string", Description: "The name of the language."}, langNodeIndexName, nil},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewLang() },
+		},
+		{
+			name: "penalty",
+			class: &luaClass{
+				name:        luaPenaltyNodeTypeName,
+				description: "A penalty holds information about a possible line break point.",
+				fields: []*luaField{
+					{Value{Name: "penalty", Type: "number", Description: "The penalty value."}, penaltyNodeIndexPenalty, penaltyNodeNewIndexPenalty},
+					{Value{Name: "width", Type: "number", Description: "The width of the penalty."}, penaltyNodeIndexWidth, penaltyNodeNewIndexWidth},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewPenalty() },
+		},
+		{
+			name: "vlist",
+			class: &luaClass{
+				name:        luaVlistNodeTypeName,
+				description: "A vertical list.",
+				fields: []*luaField{
+					{listField, vlistIndexList, vlistNewIndexList},
+					{widthField, vlistIndexWidth, vlistNewIndexWidth},
+					{heightField, vlistIndexHeight, vlistNewIndexHeight},
+					{depthField, vlistIndexDepth, vlistNewIndexDepth},
+				},
+			},
+			create: func() bagnode.Node { return bagnode.NewVList() },
+		},
+	} {
+		nt.class.parent = nodeClass
+		nodeTypes[nt.name] = nt
+		nodeTypesByGoType[reflect.TypeOf(nt.create())] = nt
+	}
 }
 
 // Registers my node type to given l.
 func registerNodeType(l *lua.LState) {
-	mt := l.NewTypeMetatable(luaNodeTypeName)
-	l.SetGlobal("node", mt)
-	l.SetField(mt, "new", l.NewFunction(newNode))
-	l.SetField(mt, "append_lineend", l.NewFunction(nodeAppendLineEndAfter))
-	l.SetField(mt, "debug", l.NewFunction(debugNode))
-	l.SetField(mt, "hpack", l.NewFunction(nodeHpack))
-	l.SetField(mt, "insertafter", l.NewFunction(nodeInsertAfter))
-	l.SetField(mt, "insertbefore", l.NewFunction(nodeInsertBefore))
-	l.SetField(mt, "linebreak", l.NewFunction(nodeLinebreak))
+	nodeLibrary.register(l)
 }
 
 func debugNode(l *lua.LState) int {
@@ -106,13 +306,8 @@ func nodeLinebreak(l *lua.LState) int {
 	tbl := l.CheckTable(2)
 
 	settings := bagnode.NewLinebreakSettings()
-	l.Push(tbl.RawGetString("hsize"))
-	hsize := l.CheckNumber(3)
-
-	l.Push(tbl.RawGetString("lineheight"))
-	lineheight := l.CheckNumber(4)
-	settings.HSize = bag.ScaledPoint(hsize)
-	settings.LineHeight = bag.ScaledPoint(lineheight)
+	settings.HSize = bag.ScaledPoint(checkTableNumber(l, 2, tbl, "hsize"))
+	settings.LineHeight = bag.ScaledPoint(checkTableNumber(l, 2, tbl, "lineheight"))
 
 	vl, _ := bagnode.Linebreak(n, settings)
 	l.Push(newUserDataFromNode(l, vl))
@@ -120,34 +315,14 @@ func nodeLinebreak(l *lua.LState) int {
 }
 
 func newNode(l *lua.LState) int {
-	switch l.CheckString(1) {
-	case "disc":
-		l.Push(newUserDataFromNode(l, bagnode.NewDisc()))
-		return 1
-	case "glue":
-		l.Push(newUserDataFromNode(l, bagnode.NewGlue()))
-		return 1
-	case "glyph":
-		l.Push(newUserDataFromNode(l, bagnode.NewGlyph()))
-		return 1
-	case "hlist":
-		l.Push(newUserDataFromNode(l, bagnode.NewHList()))
-		return 1
-	case "image":
-		l.Push(newUserDataFromNode(l, bagnode.NewImage()))
-		return 1
-	case "lang":
-		l.Push(newUserDataFromNode(l, bagnode.NewLang()))
-		return 1
-	case "penalty":
-		l.Push(newUserDataFromNode(l, bagnode.NewPenalty()))
-		return 1
-	case "vlist":
-		l.Push(newUserDataFromNode(l, bagnode.NewVList()))
-		return 1
-	default:
-		panic("nyi (newNode)")
+	typename := l.CheckString(1)
+	nt, ok := nodeTypes[typename]
+	if !ok {
+		l.ArgError(1, fmt.Sprintf("unknown node type %s", typename))
+		return 0
 	}
+	l.Push(newUserDataFromNode(l, nt.create()))
+	return 1
 }
 
 func checkIsNode(l *lua.LState) bool {
@@ -156,47 +331,47 @@ func checkIsNode(l *lua.LState) bool {
 }
 
 func newUserDataFromNode(l *lua.LState, n bagnode.Node) *lua.LUserData {
-	var mt *lua.LTable
-	switch n.(type) {
-	case *bagnode.Disc:
-		mt = l.NewTypeMetatable(luaDiscNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(discIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(discNewIndex))
-	case *bagnode.Glue:
-		mt = l.NewTypeMetatable(luaGlueNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(glueIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(glueNewIndex))
-	case *bagnode.Glyph:
-		mt = l.NewTypeMetatable(luaGlyphNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(glyphIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(glyphNewIndex))
-	case *bagnode.HList:
-		mt = l.NewTypeMetatable(luaHlistNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(hlistIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(hlistNewIndex))
-	case *bagnode.Image:
-		mt = l.NewTypeMetatable(luaImageNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(imageNodeIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(imageNodeNewIndex))
-	case *bagnode.Lang:
-		mt = l.NewTypeMetatable(luaLangNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(langNodeIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(langNodeNewIndex))
-	case *bagnode.Penalty:
-		mt = l.NewTypeMetatable(luaPenaltyNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(penaltyNodeIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(penaltyNodeNewIndex))
-	case *bagnode.VList:
-		mt = l.NewTypeMetatable(luaVlistNodeTypeName)
-		l.SetField(mt, "__index", l.NewFunction(vlistIndex))
-		l.SetField(mt, "__newindex", l.NewFunction(vlistNewIndex))
-	default:
+	nt, ok := nodeTypesByGoType[reflect.TypeOf(n)]
+	if !ok {
 		panic("nyi newUserDataFromNode")
 	}
-	ud := l.NewUserData()
-	ud.Value = n
-	l.SetMetatable(ud, mt)
-	return ud
+	return nt.class.newUserData(l, n)
+}
+
+// pushNode pushes the node n to the stack. Nothing is pushed if n is nil.
+func pushNode(l *lua.LState, n bagnode.Node) int {
+	if n == nil {
+		return 0
+	}
+	l.Push(newUserDataFromNode(l, n))
+	return 1
+}
+
+// checkOptionalNode returns the node at the stack position or nil if the value
+// is nil.
+func checkOptionalNode(l *lua.LState, argpos int) bagnode.Node {
+	if l.Get(argpos) == lua.LNil {
+		return nil
+	}
+	return checkNode(l, argpos)
+}
+
+func nodeIndexNext(l *lua.LState) int {
+	return pushNode(l, checkNode(l, 1).Next())
+}
+
+func nodeIndexPrev(l *lua.LState) int {
+	return pushNode(l, checkNode(l, 1).Prev())
+}
+
+func nodeNewIndexNext(l *lua.LState) int {
+	checkNode(l, 1).SetNext(checkOptionalNode(l, 3))
+	return 0
+}
+
+func nodeNewIndexPrev(l *lua.LState) int {
+	checkNode(l, 1).SetPrev(checkOptionalNode(l, 3))
+	return 0
 }
 
 /*
@@ -214,63 +389,12 @@ func checkDisc(l *lua.LState, argpos int) *bagnode.Disc {
 	return nil
 }
 
-func discIndex(l *lua.LState) int {
-	n := checkDisc(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "pre":
-		var other bagnode.Node
-		if other = n.Pre; other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in disc", arg))
-	}
-	return 0
+func discIndexPre(l *lua.LState) int {
+	return pushNode(l, checkDisc(l, 1).Pre)
 }
 
-func discNewIndex(l *lua.LState) int {
-	n := checkDisc(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "pre":
-		if l.Get(3) == lua.LNil {
-			n.Pre = nil
-		} else {
-			n.Pre = checkNode(l, 3)
-		}
-		return 0
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in disc", arg))
-	}
+func discNewIndexPre(l *lua.LState) int {
+	checkDisc(l, 1).Pre = checkOptionalNode(l, 3)
 	return 0
 }
 
@@ -288,76 +412,46 @@ func checkGlyph(l *lua.LState, argpos int) *bagnode.Glyph {
 	return nil
 }
 
-func glyphNewIndex(l *lua.LState) int {
-	n := checkGlyph(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "codepoint":
-		arg := l.CheckNumber(3)
-		n.Codepoint = int(arg)
-	case "components":
-		arg := l.CheckString(3)
-		n.Components = arg
-	case "font":
-		arg := checkFont(l, 3)
-		n.Font = arg
-	case "hyphenate":
-		arg := l.CheckBool(3)
-		n.Hyphenate = arg
-	case "width":
-		wd := l.CheckNumber(3)
-		n.Width = bag.ScaledPoint(wd)
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in glyph", arg))
-	}
+func glyphNewIndexCodepoint(l *lua.LState) int {
+	checkGlyph(l, 1).Codepoint = int(l.CheckNumber(3))
 	return 0
 }
 
-func glyphIndex(l *lua.LState) int {
-	n := checkGlyph(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "codepoint":
-		l.Push(lua.LNumber(n.Codepoint))
-		return 1
-	case "components":
-		l.Push(lua.LString(n.Components))
-		return 1
-	case "width":
-		l.Push(lua.LNumber(n.Width))
-		return 1
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in glyph", arg))
-	}
+func glyphNewIndexComponents(l *lua.LState) int {
+	checkGlyph(l, 1).Components = l.CheckString(3)
+	return 0
+}
+
+func glyphNewIndexFont(l *lua.LState) int {
+	checkGlyph(l, 1).Font = checkFont(l, 3)
+	return 0
+}
+
+func glyphNewIndexHyphenate(l *lua.LState) int {
+	checkGlyph(l, 1).Hyphenate = l.CheckBool(3)
+	return 0
+}
+
+func glyphNewIndexWidth(l *lua.LState) int {
+	checkGlyph(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
 	return 0
 }
 
+func glyphIndexCodepoint(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlyph(l, 1).Codepoint))
+	return 1
+}
+
+func glyphIndexComponents(l *lua.LState) int {
+	l.Push(lua.LString(checkGlyph(l, 1).Components))
+	return 1
+}
+
+func glyphIndexWidth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlyph(l, 1).Width))
+	return 1
+}
+
 /*
 
 	Glue nodes
@@ -373,81 +467,53 @@ func checkGlue(l *lua.LState, argpos int) *bagnode.Glue {
 	return nil
 }
 
-func glueIndex(l *lua.LState) int {
-	n := checkGlue(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "width":
-		l.Push(lua.LNumber(n.Width))
-		return 1
-	case "stretch":
-		l.Push(lua.LNumber(n.Stretch))
-		return 1
-	case "shrink":
-		l.Push(lua.LNumber(n.Shrink))
-		return 1
-	case "stretch_order":
-		l.Push(lua.LNumber(n.StretchOrder))
-		return 1
-	case "shrink_order":
-		l.Push(lua.LNumber(n.ShrinkOrder))
-		return 1
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in glue", arg))
-		return 0
-	}
+func glueIndexWidth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlue(l, 1).Width))
+	return 1
 }
 
-func glueNewIndex(l *lua.LState) int {
-	n := checkGlue(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "width":
-		arg := l.CheckNumber(3)
-		n.Width = bag.ScaledPoint(arg)
-	case "stretch":
-		arg := l.CheckNumber(3)
-		n.Stretch = bag.ScaledPoint(arg)
-	case "shrink":
-		arg := l.CheckNumber(3)
-		n.Shrink = bag.ScaledPoint(arg)
-	case "stretch_order":
-		arg := l.CheckNumber(3)
-		n.StretchOrder = bagnode.GlueOrder(arg)
-	case "shrink_order":
-		arg := l.CheckNumber(3)
-		n.ShrinkOrder = bagnode.GlueOrder(arg)
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in glue", arg))
-		return 0
+func glueIndexStretch(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlue(l, 1).Stretch))
+	return 1
+}
 
-	}
+func glueIndexShrink(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlue(l, 1).Shrink))
+	return 1
+}
+
+func glueIndexStretchOrder(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlue(l, 1).StretchOrder))
+	return 1
+}
+
+func glueIndexShrinkOrder(l *lua.LState) int {
+	l.Push(lua.LNumber(checkGlue(l, 1).ShrinkOrder))
+	return 1
+}
+
+func glueNewIndexWidth(l *lua.LState) int {
+	checkGlue(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func glueNewIndexStretch(l *lua.LState) int {
+	checkGlue(l, 1).Stretch = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func glueNewIndexShrink(l *lua.LState) int {
+	checkGlue(l, 1).Shrink = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func glueNewIndexStretchOrder(l *lua.LState) int {
+	checkGlue(l, 1).StretchOrder = bagnode.GlueOrder(l.CheckNumber(3))
+	return 0
+}
+
+func glueNewIndexShrinkOrder(l *lua.LState) int {
+	checkGlue(l, 1).ShrinkOrder = bagnode.GlueOrder(l.CheckNumber(3))
 	return 0
 }
 
@@ -465,117 +531,51 @@ func checkHlist(l *lua.LState, argpos int) *bagnode.HList {
 	return nil
 }
 
-func hlistIndex(l *lua.LState) int {
-	var other bagnode.Node
-	n := checkHlist(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "list":
-		if other = n.List; other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in hlist", arg))
-		return 0
-	}
+func hlistIndexList(l *lua.LState) int {
+	return pushNode(l, checkHlist(l, 1).List)
 }
 
-func hlistNewIndex(l *lua.LState) int {
-	n := checkHlist(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "list":
-		n.List = checkNode(l, 3)
-		return 0
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in hlist", arg))
-		return 0
-	}
+func hlistNewIndexList(l *lua.LState) int {
+	checkHlist(l, 1).List = checkNode(l, 3)
+	return 0
 }
 
-/*
+func hlistIndexWidth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkHlist(l, 1).Width))
+	return 1
+}
 
-	Image nodes
+func hlistIndexHeight(l *lua.LState) int {
+	l.Push(lua.LNumber(checkHlist(l, 1).Height))
+	return 1
+}
 
-*/
-func imageNodeIndex(l *lua.LState) int {
-	n := checkImageNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in image", arg))
-		return 0
-	}
+func hlistIndexDepth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkHlist(l, 1).Depth))
+	return 1
 }
-func imageNodeNewIndex(l *lua.LState) int {
-	n := checkImageNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "img":
-		n.Img = checkImage(l, 3)
-	case "width":
-		n.Width = bag.ScaledPoint(l.CheckNumber(3))
-	case "height":
-		n.Height = bag.ScaledPoint(l.CheckNumber(3))
-	default:
-		l.ArgError(2, fmt.Sprintf("unknown field %s in image", arg))
-		return 0
-	}
+
+func hlistNewIndexWidth(l *lua.LState) int {
+	checkHlist(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func hlistNewIndexHeight(l *lua.LState) int {
+	checkHlist(l, 1).Height = bag.ScaledPoint(l.CheckNumber(3))
 	return 0
 }
 
+func hlistNewIndexDepth(l *lua.LState) int {
+	checkHlist(l, 1).Depth = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+/*
+
+	Image nodes
+
+*/
+
 func checkImageNode(l *lua.LState, argpos int) *bagnode.Image {
 	ud := l.CheckUserData(argpos)
 	if v, ok := ud.Value.(*bagnode.Image); ok {
@@ -585,6 +585,21 @@ func checkImageNode(l *lua.LState, argpos int) *bagnode.Image {
 	return nil
 }
 
+func imageNodeNewIndexImg(l *lua.LState) int {
+	checkImageNode(l, 1).Img = checkImage(l, 3)
+	return 0
+}
+
+func imageNodeNewIndexWidth(l *lua.LState) int {
+	checkImageNode(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func imageNodeNewIndexHeight(l *lua.LState) int {
+	checkImageNode(l, 1).Height = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
 /*
 
 	Lang nodes
@@ -600,52 +615,18 @@ func checkLangNode(l *lua.LState, argpos int) *bagnode.Lang {
 	return nil
 }
 
-func langNodeNewIndex(l *lua.LState) int {
-	n := checkLangNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "lang":
-		pf := checkPatternFile(l, 3)
-		n.Lang = pf
-	}
+func langNodeNewIndexLang(l *lua.LState) int {
+	checkLangNode(l, 1).Lang = checkPatternFile(l, 3)
 	return 0
 }
 
-func langNodeIndex(l *lua.LState) int {
+func langNodeIndexName(l *lua.LState) int {
 	n := checkLangNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "name":
-		l.Push(lua.LString(n.Lang.Name))
-		return 1
+	if n.Lang == nil {
+		return 0
 	}
-	return 0
+	l.Push(lua.LString(n.Lang.Name))
+	return 1
 }
 
 /*
@@ -663,59 +644,26 @@ func checkPenaltyNode(l *lua.LState, argpos int) *bagnode.Penalty {
 	return nil
 }
 
-func penaltyNodeNewIndex(l *lua.LState) int {
-	n := checkPenaltyNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "penalty":
-		n.Penalty = l.CheckInt(3)
-	case "width":
-		wd := l.CheckNumber(3)
-		n.Width = bag.ScaledPoint(wd)
-	}
+func penaltyNodeNewIndexPenalty(l *lua.LState) int {
+	checkPenaltyNode(l, 1).Penalty = l.CheckInt(3)
 	return 0
 }
 
-func penaltyNodeIndex(l *lua.LState) int {
-	n := checkPenaltyNode(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "penalty":
-		l.Push(lua.LNumber(n.Penalty))
-		return 1
-	case "width":
-		l.Push(lua.LNumber(n.Width))
-		return 1
-	}
+func penaltyNodeNewIndexWidth(l *lua.LState) int {
+	checkPenaltyNode(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
 	return 0
 }
 
+func penaltyNodeIndexPenalty(l *lua.LState) int {
+	l.Push(lua.LNumber(checkPenaltyNode(l, 1).Penalty))
+	return 1
+}
+
+func penaltyNodeIndexWidth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkPenaltyNode(l, 1).Width))
+	return 1
+}
+
 /*
 
 	VList nodes
@@ -731,55 +679,41 @@ func checkVList(l *lua.LState, argpos int) *bagnode.VList {
 	return nil
 }
 
-func vlistNewIndex(l *lua.LState) int {
-	n := checkVList(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "prev":
-		if l.Get(3) == lua.LNil {
-			n.SetNext(nil)
-		} else {
-			n.SetNext(checkNode(l, 3))
-		}
-		return 0
-	case "list":
-		newnode := checkNode(l, 3)
-		n.List = newnode
-	}
+func vlistNewIndexList(l *lua.LState) int {
+	checkVList(l, 1).List = checkNode(l, 3)
 	return 0
 }
 
-func vlistIndex(l *lua.LState) int {
-	n := checkVList(l, 1)
-	switch arg := l.ToString(2); arg {
-	case "next":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "prev":
-		var other bagnode.Node
-		if other = n.Next(); other == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, other))
-		return 1
-	case "list":
-		if n.List == nil {
-			return 0
-		}
-		l.Push(newUserDataFromNode(l, n.List))
-		return 1
-	default:
-		fmt.Println("arg", arg)
-	}
+func vlistIndexList(l *lua.LState) int {
+	return pushNode(l, checkVList(l, 1).List)
+}
+
+func vlistIndexWidth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkVList(l, 1).Width))
+	return 1
+}
+
+func vlistIndexHeight(l *lua.LState) int {
+	l.Push(lua.LNumber(checkVList(l, 1).Height))
+	return 1
+}
+
+func vlistIndexDepth(l *lua.LState) int {
+	l.Push(lua.LNumber(checkVList(l, 1).Depth))
+	return 1
+}
+
+func vlistNewIndexWidth(l *lua.LState) int {
+	checkVList(l, 1).Width = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func vlistNewIndexHeight(l *lua.LState) int {
+	checkVList(l, 1).Height = bag.ScaledPoint(l.CheckNumber(3))
+	return 0
+}
+
+func vlistNewIndexDepth(l *lua.LState) int {
+	checkVList(l, 1).Depth = bag.ScaledPoint(l.CheckNumber(3))
 	return 0
 }
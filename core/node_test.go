@@ -0,0 +1,64 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// runLua runs the Lua code in a session without output files.
+func runLua(t *testing.T, src string) error {
+	t.Helper()
+	s := &Session{
+		Luafile:   "test.lua",
+		Source:    src,
+		Logger:    zap.NewNop().Sugar(),
+		CatchExit: true,
+	}
+	return s.Run()
+}
+
+func TestNodePrevNext(t *testing.T) {
+	src := `
+local a = node.new("glue")
+local b = node.new("glue")
+a.width = 1
+b.width = 2
+a.next = b
+b.prev = a
+assert(a.next.width == 2, "a.next")
+assert(b.prev.width == 1, "b.prev")
+assert(a.prev == nil, "a.prev")
+assert(b.next == nil, "b.next")
+`
+	if err := runLua(t, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNodeUnknownField(t *testing.T) {
+	for _, tc := range []struct {
+		nodetype string
+		code     string
+	}{
+		{"lang", "local x = n.foo"},
+		{"lang", "n.foo = 1"},
+		{"penalty", "local x = n.foo"},
+		{"penalty", "n.foo = 1"},
+		{"vlist", "local x = n.foo"},
+		{"vlist", "n.foo = 1"},
+	} {
+		src := `local n = node.new("` + tc.nodetype + `") ` + tc.code
+		err := runLua(t, src)
+		if err == nil || !strings.Contains(err.Error(), "unknown field foo") {
+			t.Errorf("%s: %s: got error %v, want unknown field foo", tc.nodetype, tc.code, err)
+		}
+	}
+}
+
+func TestLangNodeName(t *testing.T) {
+	if err := runLua(t, `assert(node.new("lang").name == nil)`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,8 +1,6 @@
 package core
 
 import (
-	"fmt"
-
 	"github.com/speedata/boxesandglue/document"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -11,6 +9,7 @@ const luaPageTypeName = "page"
 
 type documentPage struct {
 	page *document.Page
+	doc  *doc
 }
 
 func checkPage(l *lua.LState, argpos int) *documentPage {
@@ -18,49 +17,52 @@ func checkPage(l *lua.LState, argpos int) *documentPage {
 	if v, ok := ud.Value.(*documentPage); ok {
 		return v
 	}
-	fmt.Printf("ud.Value %#v\n", ud.Value)
 	l.ArgError(argpos, "page expected")
 	return nil
 }
 
-func newUserdataPage(l *lua.LState, p *document.Page) *lua.LUserData {
-	dp := &documentPage{page: p}
-	ud := l.NewUserData()
-	ud.Value = dp
-	mt := l.NewTypeMetatable(luaPageTypeName)
-	l.SetField(mt, "__index", l.NewFunction(pageIndex))
-	l.SetMetatable(ud, mt)
-	return ud
+// pageClass describes the pages of a document.
+var pageClass = &luaClass{
+	name:        luaPageTypeName,
+	description: "A page of a document from `doc.newpage()` or `doc.currentpage()`.",
+	methods: []*luaMethod{
+		{Function{
+			Name:        "shipout",
+			Description: "Write the page to the PDF file.",
+		}, func(l *lua.LState) lua.LGFunction { return pageShipoutFunc(checkPage(l, 1)) }},
+	},
 }
 
-func pageIndex(l *lua.LState) int {
-	p := checkPage(l, 1)
-	switch l.CheckString(2) {
-	case "shipout":
-		l.Push(l.NewFunction(pageShipoutFunc(p)))
-		return 1
-	}
-	return 0
+func newUserdataPage(l *lua.LState, p *document.Page, d *doc) *lua.LUserData {
+	return pageClass.newUserData(l, &documentPage{page: p, doc: d})
 }
 
 func pageShipoutFunc(p *documentPage) lua.LGFunction {
 	return func(l *lua.LState) int {
+		s := sessionFromState(l)
+		if !p.page.Finished {
+			if s.MaxPages > 0 && s.pages >= s.MaxPages {
+				limitError(l, "page.shipout", "the document exceeds the limit of %d pages", s.MaxPages)
+			}
+			s.pages++
+		}
 		p.page.Shipout()
+		checkOutputSize(l, p.doc, "page.shipout")
 		return 0
 	}
 }
 
-func documentCurrentPage(doc *document.Document) lua.LGFunction {
+func documentCurrentPage(d *doc) lua.LGFunction {
 	return func(l *lua.LState) int {
-		l.Push(newUserdataPage(l, doc.CurrentPage))
+		l.Push(newUserdataPage(l, d.d.CurrentPage, d))
 		return 1
 	}
 }
 
-func documentNewPage(doc *document.Document) lua.LGFunction {
+func documentNewPage(d *doc) lua.LGFunction {
 	return func(l *lua.LState) int {
-		p := doc.NewPage()
-		l.Push(newUserdataPage(l, p))
+		p := d.d.NewPage()
+		l.Push(newUserdataPage(l, p, d))
 		return 1
 	}
 }
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// profileCategories maps the ets functions to the categories in the profile
+// report. Functions not listed here are in the category "other".
+var profileCategories = map[string]string{
+	"doc.createFont":       "fonts",
+	"doc.loadFace":         "fonts",
+	"doc.newfontfamily":    "fonts",
+	"fontfamily.addmember": "fonts",
+	"doc.mknodes":          "shaping",
+	"font.shape":           "shaping",
+	"doc.hyphenate":        "hyphenation",
+	"doc.loadpattern":      "hyphenation",
+	"node.hpack":           "line breaking",
+	"node.linebreak":       "line breaking",
+	"node.append_lineend":  "nodes",
+	"node.insertafter":     "nodes",
+	"node.insertbefore":    "nodes",
+	"node.new":             "nodes",
+	"doc.createimage":      "images",
+	"doc.loadimagefile":    "images",
+	"page.shipout":         "shipout",
+	"doc.finish":           "finish",
+}
+
+// profileLua is the category for the time spent in Lua code.
+const profileLua = "lua"
+
+type profileEntry struct {
+	calls    int
+	duration time.Duration
+}
+
+// A Profile records how much time is spent in the ets functions and in Lua
+// code. The Lua time is the time between two calls of ets functions, it is
+// assigned to the Lua functions on the call stack of the next ets function.
+// A Profile can be shared by sessions running concurrently.
+type Profile struct {
+	mu         sync.Mutex
+	start      time.Time
+	functions  map[string]*profileEntry
+	stacks     map[string]time.Duration
+	outputSize int64
+	// last is the end of the last ets function call for each Lua state
+	last map[*lua.LState]time.Time
+}
+
+// NewProfile returns a new profile. The total time starts now.
+func NewProfile() *Profile {
+	return &Profile{
+		start:     time.Now(),
+		functions: map[string]*profileEntry{},
+		stacks:    map[string]time.Duration{},
+		last:      map[*lua.LState]time.Time{},
+	}
+}
+
+// profiled returns a function that records the duration of each call of fn
+// in the profile of the session, if there is one. name is the documented name
+// of the function, such as node.linebreak.
+func profiled(name string, fn lua.LGFunction) lua.LGFunction {
+	return func(l *lua.LState) int {
+		p := sessionFromState(l).Profile
+		if p == nil {
+			return fn(l)
+		}
+		stack := luaStack(l)
+		start := time.Now()
+		p.addLuaTime(l, stack, start)
+		ret := fn(l)
+		p.addCall(l, name, stack, start)
+		return ret
+	}
+}
+
+// luaStack returns the Lua functions on the call stack of the current Go
+// function, outermost first, separated by semicolons.
+func luaStack(l *lua.LState) string {
+	var frames []string
+	for i := 1; ; i++ {
+		dbg, ok := l.GetStack(i)
+		if !ok {
+			break
+		}
+		if _, err := l.GetInfo("Sn", dbg, lua.LNil); err != nil || dbg.What == "G" {
+			continue
+		}
+		if dbg.What == "main" {
+			frames = append(frames, fmt.Sprintf("main chunk (%s)", dbg.Source))
+		} else {
+			frames = append(frames, fmt.Sprintf("%s (%s:%d)", dbg.Name, dbg.Source, dbg.LineDefined))
+		}
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return strings.Join(frames, ";")
+}
+
+// startRun sets the start time of Lua code for the Lua state.
+func (p *Profile) startRun(l *lua.LState) {
+	p.mu.Lock()
+	p.last[l] = time.Now()
+	p.mu.Unlock()
+}
+
+// endRun adds the Lua time since the last ets function call.
+func (p *Profile) endRun(l *lua.LState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, ok := p.last[l]; ok {
+		p.entry(profileLua).duration += time.Since(last)
+		delete(p.last, l)
+	}
+}
+
+func (p *Profile) entry(name string) *profileEntry {
+	e, ok := p.functions[name]
+	if !ok {
+		e = &profileEntry{}
+		p.functions[name] = e
+	}
+	return e
+}
+
+func (p *Profile) addLuaTime(l *lua.LState, stack string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, ok := p.last[l]; ok {
+		d := now.Sub(last)
+		p.entry(profileLua).duration += d
+		if stack != "" {
+			p.stacks[stack] += d
+		}
+	}
+}
+
+func (p *Profile) addCall(l *lua.LState, name string, stack string, start time.Time) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := now.Sub(start)
+	e := p.entry(name)
+	e.calls++
+	e.duration += d
+	if stack != "" {
+		name = stack + ";" + name
+	}
+	p.stacks[name] += d
+	p.last[l] = now
+}
+
+// addOutput adds the size of a PDF file to the output size.
+func (p *Profile) addOutput(size int64) {
+	p.mu.Lock()
+	p.outputSize += size
+	p.mu.Unlock()
+}
+
+func (p *Profile) calls(name string) int {
+	if e, ok := p.functions[name]; ok {
+		return e.calls
+	}
+	return 0
+}
+
+// WriteReport writes the time spent per category, the number of pages, fonts
+// and images and the size of the PDF files.
+func (p *Profile) WriteReport(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := time.Since(p.start)
+	categories := map[string]*profileEntry{}
+	for name, e := range p.functions {
+		cat := profileCategories[name]
+		switch {
+		case name == profileLua:
+			cat = profileLua
+		case cat == "":
+			cat = "other"
+		}
+		ce, ok := categories[cat]
+		if !ok {
+			ce = &profileEntry{}
+			categories[cat] = ce
+		}
+		ce.calls += e.calls
+		ce.duration += e.duration
+	}
+	names := make([]string, 0, len(categories))
+	for cat := range categories {
+		names = append(names, cat)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return categories[names[i]].duration > categories[names[j]].duration
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "category\tcalls\ttime\t%\t")
+	for _, cat := range names {
+		ce := categories[cat]
+		calls := fmt.Sprint(ce.calls)
+		if cat == profileLua {
+			calls = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\t\n", cat, calls, ce.duration.Round(time.Microsecond), 100*float64(ce.duration)/float64(total))
+	}
+	fmt.Fprintf(tw, "total\t\t%s\t\t\n", total.Round(time.Microsecond))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "pages: %d, fonts: %d, images: %d, output size: %d bytes\n",
+		p.calls("page.shipout"), p.calls("doc.loadFace"), p.calls("doc.loadimagefile"), p.outputSize)
+	return err
+}
+
+// WriteLuaProfile writes the time in microseconds spent in each Lua call
+// stack in the collapsed stack format (one line per stack, the functions
+// separated by semicolons, followed by the time) which can be turned into a
+// flame graph. Time spent in ets functions is shown as the last entry of the
+// stack.
+func (p *Profile) WriteLuaProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stacks := make([]string, 0, len(p.stacks))
+	for stack := range p.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if us := p.stacks[stack].Microseconds(); us > 0 {
+			if _, err := fmt.Fprintf(w, "%s %d\n", stack, us); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteStubs writes a Lua file with annotations for the Lua language server
+// (EmmyLua style) that describes the libraries and objects of ets. Editors use
+// the file for completion and hover information.
+func WriteStubs(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "---@meta")
+	fmt.Fprintln(bw, `-- Annotations for the Lua libraries of ets, generated with "ets stubs". Do not edit.`)
+	for _, cls := range API() {
+		fmt.Fprintln(bw)
+		fmt.Fprintf(bw, "---%s\n", cls.Description)
+		if cls.Parent != "" {
+			fmt.Fprintf(bw, "---@class %s : %s\n", cls.Name, cls.Parent)
+		} else {
+			fmt.Fprintf(bw, "---@class %s\n", cls.Name)
+		}
+		for _, f := range cls.Fields {
+			fmt.Fprintf(bw, "---@field %s %s %s%s\n", f.Name, f.Type, f.Description, accessNote(f))
+		}
+		table := cls.Global
+		switch {
+		case table != "":
+			fmt.Fprintf(bw, "%s = {}\n", table)
+		case len(cls.Functions) > 0:
+			// methods are called with a dot, d.newpage()
+			table = cls.Name
+			fmt.Fprintf(bw, "local %s = {}\n", table)
+		}
+		for _, fn := range cls.Functions {
+			fmt.Fprintln(bw)
+			fmt.Fprintf(bw, "---%s\n", fn.Description)
+			var args []string
+			for _, arg := range fn.Args {
+				name := arg.Name
+				if arg.Optional {
+					name += "?"
+				}
+				fmt.Fprintf(bw, "---@param %s %s %s\n", name, arg.Type, arg.Description)
+				args = append(args, arg.Name)
+			}
+			for _, ret := range fn.Returns {
+				typ := ret.Type
+				if ret.Optional {
+					typ += "?"
+				}
+				fmt.Fprintf(bw, "---@return %s %s %s\n", typ, ret.Name, ret.Description)
+			}
+			fmt.Fprintf(bw, "function %s.%s(%s) end\n", table, fn.Name, strings.Join(args, ", "))
+		}
+	}
+	return bw.Flush()
+}
+
+// accessNote returns a note for fields that can only be read or only be set.
+func accessNote(f Field) string {
+	switch {
+	case f.Read && !f.Write:
+		return " (read only)"
+	case f.Write && !f.Read:
+		return " (write only)"
+	}
+	return ""
+}
+
+// adocEscape escapes the table separator in AsciiDoc table cells.
+func adocEscape(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+// adocValues formats arguments or return values for the reference.
+func adocValues(values []Value) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	var ret []string
+	for _, v := range values {
+		s := fmt.Sprintf("%s `%s`", v.Name, adocEscape(v.Type))
+		if v.Optional {
+			s += " (optional)"
+		}
+		ret = append(ret, s)
+	}
+	return strings.Join(ret, ", ")
+}
+
+// WriteReference writes the reference of the Lua libraries and objects in
+// AsciiDoc format. The reference is included in the manual.
+func WriteReference(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `// Generated with "ets stubs --format adoc", do not edit.`)
+	classes := API()
+	for _, cls := range classes {
+		switch {
+		case cls.Global != "":
+			fmt.Fprintf(bw, "\n=== Library `%s`\n\n", cls.Global)
+		case cls.Name == luaNodeTypeName:
+			fmt.Fprintf(bw, "\n=== Nodes\n\n")
+			fmt.Fprintln(bw, "|===")
+			fmt.Fprintln(bw, "| Node name | Lua type | Description")
+			for _, nt := range classes {
+				if nt.NodeType != "" {
+					fmt.Fprintf(bw, "| `%s` | `%s` | %s\n", nt.NodeType, nt.Name, nt.Description)
+				}
+			}
+			fmt.Fprint(bw, "|===\n\n")
+		case cls.NodeType != "":
+			fmt.Fprintf(bw, "\n==== `%s`\n\n", cls.NodeType)
+		default:
+			fmt.Fprintf(bw, "\n=== Object `%s`\n\n", cls.Name)
+		}
+		fmt.Fprintf(bw, "%s\n", cls.Description)
+		if len(cls.Functions) > 0 {
+			fmt.Fprintln(bw)
+			fmt.Fprintln(bw, "|===")
+			fmt.Fprintln(bw, "| Function | Arguments | Return value | Description")
+			for _, fn := range cls.Functions {
+				fmt.Fprintf(bw, "| `%s()` | %s | %s | %s\n", fn.Name, adocValues(fn.Args), adocValues(fn.Returns), adocEscape(fn.Description))
+			}
+			fmt.Fprintln(bw, "|===")
+		}
+		if len(cls.Fields) > 0 {
+			fmt.Fprintln(bw)
+			fmt.Fprintln(bw, "|===")
+			fmt.Fprintln(bw, "| Field name | Value | Description")
+			for _, f := range cls.Fields {
+				fmt.Fprintf(bw, "| `%s` | `%s` | %s%s\n", f.Name, adocEscape(f.Type), adocEscape(f.Description), accessNote(f))
+			}
+			fmt.Fprintln(bw, "|===")
+		}
+	}
+	return bw.Flush()
+}
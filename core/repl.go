@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/speedata/boxesandglue/backend/font"
+	"github.com/speedata/boxesandglue/backend/image"
+	"github.com/speedata/boxesandglue/backend/lang"
+	bagnode "github.com/speedata/boxesandglue/backend/node"
+	"github.com/speedata/boxesandglue/document"
+	"github.com/speedata/boxesandglue/pdfbackend/pdf"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	replPrompt             = "> "
+	replContinuationPrompt = ">> "
+)
+
+// Repl reads Lua statements from r and executes them in a Lua state which is
+// set up like in Run. Results are printed to w. A statement that is not
+// complete yet continues on the next line.
+func (s *Session) Repl(r io.Reader, w io.Writer) error {
+	defer s.removeTempDir()
+	l, err := s.newState()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	defer s.cancel()
+	defer s.logger.Sync()
+
+	scanner := bufio.NewScanner(r)
+	var chunk []string
+	fmt.Fprint(w, replPrompt)
+	for scanner.Scan() {
+		chunk = append(chunk, scanner.Text())
+		fn, err := replCompile(l, strings.Join(chunk, "\n"))
+		if err != nil && replIncomplete(err) {
+			fmt.Fprint(w, replContinuationPrompt)
+			continue
+		}
+		chunk = chunk[:0]
+		if err != nil {
+			fmt.Fprintln(w, err)
+			fmt.Fprint(w, replPrompt)
+			continue
+		}
+		top := l.GetTop()
+		l.Push(fn)
+		if err = l.PCall(0, lua.MultRet, nil); err != nil {
+			fmt.Fprintln(w, err)
+		} else if l.GetTop() > top {
+			values := make([]string, 0, l.GetTop()-top)
+			for i := top + 1; i <= l.GetTop(); i++ {
+				values = append(values, describeValue(l.Get(i)))
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+		l.SetTop(top)
+		fmt.Fprint(w, replPrompt)
+	}
+	fmt.Fprintln(w)
+	return scanner.Err()
+}
+
+// replCompile compiles the source as an expression to get its value, and as
+// a statement if that fails.
+func replCompile(l *lua.LState, source string) (*lua.LFunction, error) {
+	if fn, err := l.Load(strings.NewReader("return "+source), "stdin"); err == nil {
+		return fn, nil
+	}
+	return l.Load(strings.NewReader(source), "stdin")
+}
+
+// replIncomplete returns true if the syntax error is caused by an unfinished
+// statement.
+func replIncomplete(err error) bool {
+	msg := strings.TrimSpace(err.Error())
+	return strings.Contains(msg, "at EOF:") && !strings.HasSuffix(msg, "unterminated string")
+}
+
+// describeValue returns a human readable representation of the value. Tables
+// are shown one level deep.
+func describeValue(lv lua.LValue) string {
+	switch v := lv.(type) {
+	case lua.LString:
+		return fmt.Sprintf("%q", string(v))
+	case *lua.LTable:
+		var items []string
+		v.ForEach(func(key, value lua.LValue) {
+			var itemvalue string
+			switch value.(type) {
+			case *lua.LTable:
+				itemvalue = "{...}"
+			default:
+				itemvalue = describeValue(value)
+			}
+			if key.Type() == lua.LTNumber {
+				items = append(items, itemvalue)
+			} else {
+				items = append(items, fmt.Sprintf("%s = %s", key.String(), itemvalue))
+			}
+		})
+		return "{" + strings.Join(items, ", ") + "}"
+	case *lua.LUserData:
+		return describeUserData(v)
+	}
+	return lv.String()
+}
+
+func describeUserData(ud *lua.LUserData) string {
+	switch t := ud.Value.(type) {
+	case *doc:
+		return fmt.Sprintf("<document %s>", t.d.Filename)
+	case *documentPage:
+		return fmt.Sprintf("<page %sx%s>", t.page.Width, t.page.Height)
+	case *pdf.Face:
+		return fmt.Sprintf("<face %s>", t.InternalName())
+	case *font.Font:
+		return fmt.Sprintf("<font %s size %s>", t.Face.InternalName(), t.Size)
+	case *document.FontFamily:
+		return fmt.Sprintf("<fontfamily %q id %d>", t.Name, t.ID)
+	case *pdf.Imagefile:
+		return fmt.Sprintf("<imagefile %s (%s, %d pages)>", t.Filename, t.Format, t.NumberOfPages)
+	case *image.Image:
+		return fmt.Sprintf("<image %s page %d>", t.ImageFile.Filename, t.PageNumber)
+	case *lang.Lang:
+		return fmt.Sprintf("<lang %q>", t.Name)
+	case bagnode.Node:
+		return describeNode(t)
+	}
+	return ud.String()
+}
+
+func describeNode(n bagnode.Node) string {
+	fields := map[string]string{}
+	switch v := n.(type) {
+	case *bagnode.Glue:
+		fields["width"] = v.Width.String()
+		fields["stretch"] = v.Stretch.String()
+		fields["shrink"] = v.Shrink.String()
+	case *bagnode.Glyph:
+		fields["width"] = v.Width.String()
+		fields["components"] = fmt.Sprintf("%q", v.Components)
+		if v.Font != nil {
+			fields["font size"] = v.Font.Size.String()
+		}
+	case *bagnode.HList:
+		fields["width"] = v.Width.String()
+		fields["height"] = v.Height.String()
+		fields["depth"] = v.Depth.String()
+	case *bagnode.Image:
+		fields["width"] = v.Width.String()
+		fields["height"] = v.Height.String()
+		if v.Img != nil && v.Img.ImageFile != nil {
+			fields["file"] = v.Img.ImageFile.Filename
+		}
+	case *bagnode.Lang:
+		if v.Lang != nil {
+			fields["lang"] = fmt.Sprintf("%q", v.Lang.Name)
+		}
+	case *bagnode.Penalty:
+		fields["penalty"] = fmt.Sprintf("%d", v.Penalty)
+		fields["width"] = v.Width.String()
+	case *bagnode.VList:
+		fields["width"] = v.Width.String()
+		fields["height"] = v.Height.String()
+		fields["depth"] = v.Depth.String()
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s %d", n.Name(), n.GetID())
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, fields[k])
+	}
+	b.WriteString(">")
+	return b.String()
+}
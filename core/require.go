@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaLibraryFiles are the Lua modules bundled with ets such as ets.nodes and
+// ets.paragraph.
+//
+//go:embed lua
+var luaLibraryFiles embed.FS
+
+// registerLuaLoader replaces the default file loader of require() with one
+// that records the loaded file as a dependency of the session. The loader of
+// the bundled modules comes before the file loader, so the modules ets.*
+// can't be replaced by files.
+func registerLuaLoader(l *lua.LState) {
+	loaders, ok := l.GetField(l.GetGlobal("package"), "loaders").(*lua.LTable)
+	if !ok {
+		return
+	}
+	l.RawSetInt(loaders, 2, l.NewFunction(libraryLoader))
+	l.RawSetInt(loaders, 3, l.NewFunction(luaLoader))
+}
+
+// libraryLoader loads the bundled Lua modules. The chunk name starts with a
+// bracket, so error reports point to the calling script.
+func libraryLoader(l *lua.LState) int {
+	name := l.CheckString(1)
+	fn := "lua/" + strings.Replace(name, ".", "/", -1) + ".lua"
+	data, err := luaLibraryFiles.ReadFile(fn)
+	if err != nil {
+		l.Push(lua.LString(fmt.Sprintf("no bundled module '%s'", name)))
+		return 1
+	}
+	f, err := l.Load(bytes.NewReader(data), "[ets]/"+strings.TrimPrefix(fn, "lua/"))
+	if err != nil {
+		l.RaiseError(err.Error())
+	}
+	l.Push(f)
+	return 1
+}
+
+func luaLoader(l *lua.LState) int {
+	name := l.CheckString(1)
+	path, ok := l.GetField(l.GetGlobal("package"), "path").(lua.LString)
+	if !ok {
+		l.RaiseError("package.path must be a string")
+	}
+	name = strings.Replace(name, ".", string(os.PathSeparator), -1)
+	messages := []string{}
+	for _, pattern := range strings.Split(string(path), ";") {
+		luapath := strings.Replace(pattern, "?", name, -1)
+		s := sessionFromState(l)
+		if _, err := s.stat(luapath); err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		if s.FS == nil {
+			if err := s.checkFile(luapath); err != nil {
+				messages = append(messages, err.Error())
+				continue
+			}
+		}
+		fn, err := s.loadLuaFile(l, luapath)
+		if err != nil {
+			l.RaiseError(err.Error())
+		}
+		s.addDependency(luapath)
+		l.Push(fn)
+		return 1
+	}
+	l.Push(lua.LString(strings.Join(messages, "\n\t")))
+	return 1
+}
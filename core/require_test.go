@@ -0,0 +1,109 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRequire(t *testing.T) {
+	dir := t.TempDir()
+	luaPath := t.TempDir()
+	searchPath := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "local.lua"), `return "dir"`)
+	writeTestFile(t, filepath.Join(dir, "ets", "nodes.lua"), `return "replaced"`)
+	writeTestFile(t, filepath.Join(luaPath, "lib", "helper.lua"), `return "luapath"`)
+	writeTestFile(t, filepath.Join(luaPath, "pkg", "init.lua"), `return "init"`)
+	writeTestFile(t, filepath.Join(luaPath, "local.lua"), `return "luapath"`)
+	writeTestFile(t, filepath.Join(searchPath, "sub", "found.lua"), `return "searchpath"`)
+	writeTestFile(t, filepath.Join(searchPath, "helper.lua"), `return "searchpath"`)
+	s := &Session{
+		Luafile:     filepath.Join(dir, "test.lua"),
+		Dir:         dir,
+		LuaPath:     []string{luaPath},
+		SearchPaths: []string{searchPath},
+		Logger:      zap.NewNop().Sugar(),
+		Source: `
+assert(require("local") == "dir", "Dir comes first")
+assert(require("lib.helper") == "luapath", "dotted name")
+assert(require("pkg") == "init", "init.lua")
+assert(require("helper") == "searchpath", "search path")
+assert(type(require("ets.nodes")) == "table", "bundled module")
+local ok, err = pcall(require, "no.mod")
+assert(not ok)
+assert(err:find("module no.mod not found:", 1, true), err)
+assert(err:find("no bundled module 'no.mod'", 1, true), err)
+assert(err:find("` + filepath.ToSlash(filepath.Join(dir, "no", "mod.lua")) + `", 1, true), err)`,
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{
+		filepath.Join(dir, "local.lua"),
+		filepath.Join(luaPath, "lib", "helper.lua"),
+		filepath.Join(luaPath, "pkg", "init.lua"),
+		filepath.Join(searchPath, "helper.lua"),
+	} {
+		found := false
+		for _, dep := range s.Dependencies {
+			found = found || dep == fn
+		}
+		if !found {
+			t.Errorf("%s is not a dependency: %q", fn, s.Dependencies)
+		}
+	}
+}
+
+func TestRequireSandbox(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "inside.lua"), `return "inside"`)
+	writeTestFile(t, filepath.Join(outside, "outside.lua"), `return "outside"`)
+	s := &Session{
+		Luafile: filepath.Join(dir, "test.lua"),
+		Dir:     dir,
+		LuaPath: []string{outside},
+		Sandbox: true,
+		Logger:  zap.NewNop().Sugar(),
+		Source: `
+assert(require("inside") == "inside")
+local ok, err = pcall(require, "outside")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`,
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "inside.lua")}; !reflect.DeepEqual(s.Dependencies, want) {
+		t.Errorf("Dependencies = %q, want %q", s.Dependencies, want)
+	}
+}
+
+func TestRequireFS(t *testing.T) {
+	fsys, err := MemFS(map[string][]byte{
+		"lib/helper.lua": []byte(`return "fs"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{
+		Luafile: "test.lua",
+		FS:      fsys,
+		Logger:  zap.NewNop().Sugar(),
+		Source: `
+assert(require("lib.helper") == "fs")
+local ok, err = pcall(require, "missing")
+assert(not ok and err:find("module missing not found", 1, true), err)`,
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dep := range s.Dependencies {
+		if strings.Contains(dep, "helper") {
+			t.Errorf("file from FS %s is a dependency", dep)
+		}
+	}
+}
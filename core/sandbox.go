@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sandboxOSFunctions are the functions of the os library that are available
+// in the sandbox.
+var sandboxOSFunctions = []string{"clock", "date", "difftime", "time"}
+
+// sandboxRemovedGlobals are the libraries and functions that are not
+// available in the sandbox.
+var sandboxRemovedGlobals = []string{"debug", "dofile", "io", "loadfile"}
+
+// applySandbox removes the functions that give access to files and other
+// programs. os.exit() stops the script instead of the process.
+func (s *Session) applySandbox(l *lua.LState) {
+	osTbl := l.NewTable()
+	if orig, ok := l.GetGlobal("os").(*lua.LTable); ok {
+		for _, name := range sandboxOSFunctions {
+			l.SetField(osTbl, name, l.GetField(orig, name))
+		}
+	}
+	l.SetField(osTbl, "exit", l.NewFunction(osExitError))
+	l.SetGlobal("os", osTbl)
+	loaded := l.GetField(l.GetGlobal("package"), "loaded")
+	l.SetField(loaded, "os", osTbl)
+	for _, name := range sandboxRemovedGlobals {
+		l.SetGlobal(name, lua.LNil)
+		l.SetField(loaded, name, lua.LNil)
+	}
+}
+
+// checkFile returns an error if the session runs in the sandbox and the file
+// is not in the session directory (or the current working directory) or one
+// of the allowed directories. The search paths are not allowed, they can be
+// set by the script.
+func (s *Session) checkFile(fn string) error {
+	if !s.Sandbox {
+		return nil
+	}
+	path, err := realPath(fn)
+	if err != nil {
+		return err
+	}
+	for _, dir := range append([]string{s.Dir}, s.AllowedDirs...) {
+		dir, err = realPath(dir)
+		if err != nil {
+			continue
+		}
+		if path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("access to %s is not allowed in the sandbox", fn)
+}
+
+// realPath returns the absolute file name with all symbolic links resolved.
+// The file itself does not need to exist.
+func realPath(fn string) (string, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+	if path, err := filepath.EvalSymlinks(abs); err == nil {
+		return path, nil
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return abs, nil
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
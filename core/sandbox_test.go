@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "data.json"), `{"ok": true}`)
+	writeTestFile(t, filepath.Join(outside, "data.json"), `{"secret": true}`)
+	writeTestFile(t, filepath.Join(outside, "font.otf"), "not a font")
+	writeTestFile(t, filepath.Join(outside, "mod.lua"), `return {}`)
+	if err := os.Symlink(filepath.Join(outside, "data.json"), filepath.Join(dir, "link.json")); err != nil {
+		t.Skip(err)
+	}
+	out := filepath.ToSlash(outside)
+	for _, tc := range []struct {
+		name        string
+		src         string
+		allowedDirs []string
+	}{
+		{name: "removed globals", src: `
+assert(io == nil and dofile == nil and loadfile == nil and debug == nil)
+assert(package.loaded.io == nil and package.loaded.debug == nil)`},
+		{name: "os library", src: `
+assert(os.execute == nil and os.getenv == nil and os.remove == nil and os.rename == nil)
+assert(type(os.time()) == "number" and type(os.clock()) == "number")`},
+		{name: "os.exit in pcall", src: `assert(not pcall(os.exit, 1))`},
+		{name: "file in the directory", src: `assert(json.decodefile("data.json").ok)`},
+		{name: "file outside", src: `
+local ok, err = json.decodefile("` + out + `/data.json")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+		{name: "file in an allowed directory", src: `assert(json.decodefile("` + out + `/data.json").secret)`, allowedDirs: []string{outside}},
+		{name: "symbolic link", src: `
+local ok, err = json.decodefile("link.json")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+		{name: "document.new", src: `
+local ok, err = document.new("` + out + `/out.pdf")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+		{name: "loadFace", src: `
+local d = document.new()
+local ok, err = d.loadFace({name = "font", source = "` + out + `/font.otf"})
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+		{name: "require", src: `
+package.path = "` + out + `/?.lua;" .. package.path
+local ok, err = pcall(require, "mod")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+		{name: "addsearchpath", src: `
+local ok, err = document.addsearchpath("` + out + `")
+assert(not ok)
+assert(err:find("not allowed in the sandbox", 1, true), err)`},
+	} {
+		s := &Session{
+			Luafile:     filepath.Join(dir, "test.lua"),
+			Source:      tc.src,
+			Dir:         dir,
+			Sandbox:     true,
+			AllowedDirs: tc.allowedDirs,
+			Logger:      zap.NewNop().Sugar(),
+		}
+		if err := s.Run(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestSandboxExit(t *testing.T) {
+	s := &Session{Luafile: "test.lua", Source: `os.exit(3)`, Sandbox: true, Logger: zap.NewNop().Sugar()}
+	err := s.Run()
+	var ee *ExitError
+	if !errors.As(err, &ee) || ee.Code != 3 {
+		t.Errorf("got error %v, want an *ExitError with code 3", err)
+	}
+}
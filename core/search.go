@@ -0,0 +1,160 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// notFoundError is returned by find if a file is not found. It lists the
+// directories that were searched.
+type notFoundError struct {
+	name string
+	dirs []string
+	// recursive is true if the subdirectories of the search paths were
+	// searched
+	recursive bool
+}
+
+func (e *notFoundError) Error() string {
+	if len(e.dirs) == 0 {
+		return fmt.Sprintf("file %s not found", e.name)
+	}
+	msg := fmt.Sprintf("file %s not found in %s", e.name, e.dirs[0])
+	if len(e.dirs) > 1 {
+		msg += " and in the search paths " + strings.Join(e.dirs[1:], ", ")
+		if e.recursive {
+			msg += " (including subdirectories)"
+		}
+	}
+	return msg
+}
+
+// Unwrap returns fs.ErrNotExist, so errors.Is(err, fs.ErrNotExist) is true.
+func (e *notFoundError) Unwrap() error {
+	return fs.ErrNotExist
+}
+
+// find returns the name of the font, image or pattern file fn. A relative
+// file name is searched in the session directory and then in the search
+// paths. A file name without directory is also searched in the
+// subdirectories of the search paths, the first file in the order of the
+// search paths (and in alphabetical order within a search path) is taken.
+func (s *Session) find(fn string) (string, error) {
+	if filepath.IsAbs(fn) {
+		if _, err := s.stat(fn); err != nil {
+			return "", &notFoundError{name: fn}
+		}
+		return fn, nil
+	}
+	dir := s.Dir
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := s.stat(filepath.Join(dir, fn)); err == nil {
+		return filepath.Join(s.Dir, fn), nil
+	}
+	for _, dir := range s.searchPaths {
+		if _, err := s.stat(filepath.Join(dir, fn)); err == nil {
+			return filepath.Join(dir, fn), nil
+		}
+	}
+	recursive := filepath.Base(fn) == fn
+	if recursive {
+		for _, dir := range s.searchPaths {
+			if name, ok := s.searchIndex(dir)[fn]; ok {
+				return name, nil
+			}
+		}
+	}
+	if s.FS == nil {
+		// the current directory is not obvious in the error message
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	return "", &notFoundError{name: fn, dirs: append([]string{dir}, s.searchPaths...), recursive: recursive}
+}
+
+// searchIndex returns the files in the directory dir and its subdirectories
+// by file name. Hidden files and directories are skipped. The index is built
+// once per run.
+func (s *Session) searchIndex(dir string) map[string]string {
+	if idx, ok := s.fileIndex[dir]; ok {
+		return idx
+	}
+	idx := map[string]string{}
+	walk := func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// skip directories that can't be read
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && d.Name() != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if _, ok := idx[d.Name()]; !ok && !d.IsDir() {
+			idx[d.Name()] = name
+		}
+		return nil
+	}
+	if s.FS != nil {
+		fs.WalkDir(s.FS, fsName(dir), walk)
+	} else {
+		filepath.WalkDir(dir, walk)
+	}
+	if s.fileIndex == nil {
+		s.fileIndex = map[string]map[string]string{}
+	}
+	s.fileIndex[dir] = idx
+	return idx
+}
+
+// documentAddSearchPath adds a directory to the search paths of the run. A
+// relative directory name is relative to the directory of the Lua file.
+func documentAddSearchPath(l *lua.LState) int {
+	s := sessionFromState(l)
+	dir := l.CheckString(1)
+	if !filepath.IsAbs(dir) {
+		// relative to the Lua file, so the script can be run from any
+		// directory
+		base := s.Dir
+		if s.Luafile != "" && s.Source == "" {
+			base = filepath.Dir(s.Luafile)
+		}
+		dir = filepath.Join(base, dir)
+	}
+	fi, err := s.stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("directory %s not found", dir)
+	}
+	if err != nil {
+		return etsError(l, ErrorIO, "document.addsearchpath", err)
+	}
+	if !fi.IsDir() {
+		return lerr(l, fmt.Sprintf("%s is not a directory", dir))
+	}
+	if s.FS == nil {
+		if err := s.checkFile(dir); err != nil {
+			return etsError(l, ErrorIO, "document.addsearchpath", err)
+		}
+	}
+	for _, sp := range s.searchPaths {
+		if sp == dir {
+			l.Push(lua.LTrue)
+			return 1
+		}
+	}
+	s.searchPaths = append(s.searchPaths, dir)
+	pkg := l.GetGlobal("package")
+	path := lua.LVAsString(l.GetField(pkg, "path")) + ";" + filepath.Join(dir, "?.lua")
+	l.SetField(pkg, "path", lua.LString(path))
+	l.Push(lua.LTrue)
+	return 1
+}
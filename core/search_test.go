@@ -0,0 +1,119 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+)
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	sp1 := t.TempDir()
+	sp2 := t.TempDir()
+	for _, fn := range []string{
+		filepath.Join(dir, "both.png"),
+		filepath.Join(sp1, "both.png"),
+		filepath.Join(sp1, "first.png"),
+		filepath.Join(sp2, "first.png"),
+		filepath.Join(sp1, "sub", "direct.png"),
+		filepath.Join(sp2, "direct.png"),
+		filepath.Join(sp1, "deep", "er", "deep.png"),
+		filepath.Join(sp1, "a", "order.png"),
+		filepath.Join(sp1, "b", "order.png"),
+		filepath.Join(sp2, "img", "logo.png"),
+		filepath.Join(sp1, ".git", "hidden.png"),
+	} {
+		writeTestFile(t, fn, "")
+	}
+	s := &Session{Dir: dir, searchPaths: []string{sp1, sp2}}
+	for _, tc := range []struct {
+		name string
+		fn   string
+		want string
+	}{
+		{"directory before search paths", "both.png", filepath.Join(dir, "both.png")},
+		{"order of the search paths", "first.png", filepath.Join(sp1, "first.png")},
+		{"file before subdirectories", "direct.png", filepath.Join(sp2, "direct.png")},
+		{"subdirectory", "deep.png", filepath.Join(sp1, "deep", "er", "deep.png")},
+		{"alphabetical order", "order.png", filepath.Join(sp1, "a", "order.png")},
+		{"relative name", "img/logo.png", filepath.Join(sp2, "img", "logo.png")},
+		{"absolute name", filepath.Join(sp1, "first.png"), filepath.Join(sp1, "first.png")},
+		{"relative name not in subdirectories", "er/deep.png", ""},
+		{"hidden directory", "hidden.png", ""},
+		{"missing", "missing.png", ""},
+	} {
+		got, err := s.find(tc.fn)
+		if tc.want == "" {
+			if err == nil || !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: got %s, %v, want a not found error", tc.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %s, %v, want %s", tc.name, got, err, tc.want)
+		}
+	}
+	_, err := s.find("missing.png")
+	if want := "file missing.png not found in " + dir + " and in the search paths " + sp1 + ", " + sp2 + " (including subdirectories)"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestFindFS(t *testing.T) {
+	s := &Session{
+		FS: fstest.MapFS{
+			"job/main.lua":          {},
+			"job/logo.png":          {},
+			"assets/img/logo.png":   {},
+			"assets/fonts/text.ttf": {},
+		},
+		Dir:         "job",
+		searchPaths: []string{"assets"},
+	}
+	for fn, want := range map[string]string{
+		"logo.png":      filepath.Join("job", "logo.png"),
+		"text.ttf":      filepath.Join("assets", "fonts", "text.ttf"),
+		"/job/main.lua": filepath.Join("/job", "main.lua"),
+	} {
+		if got, err := s.find(fn); err != nil || got != want {
+			t.Errorf("find(%s): got %s, %v, want %s", fn, got, err, want)
+		}
+	}
+}
+
+func TestAddSearchPath(t *testing.T) {
+	scriptDir := t.TempDir()
+	writeTestFile(t, filepath.Join(scriptDir, "assets", "mod.lua"), `return "assets"`)
+	writeTestFile(t, filepath.Join(scriptDir, "test.lua"), `
+assert(document.addsearchpath("assets"))
+assert(document.addsearchpath("assets"), "added twice")
+assert(require("mod") == "assets")
+local ok, err = document.addsearchpath("missing")
+assert(not ok and err:find("directory `+filepath.ToSlash(filepath.Join(scriptDir, "missing"))+` not found", 1, true), err)
+ok, err = document.addsearchpath("test.lua")
+assert(not ok and err:find("is not a directory", 1, true), err)
+require("searchpaths")`)
+	var searchPaths []string
+	s := &Session{
+		Luafile: filepath.Join(scriptDir, "test.lua"),
+		// the search path is relative to the Lua file, not to Dir
+		Dir:    t.TempDir(),
+		Logger: zap.NewNop().Sugar(),
+		Modules: map[string]lua.LGFunction{"searchpaths": func(l *lua.LState) int {
+			searchPaths = sessionFromState(l).searchPaths
+			return 0
+		}},
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(scriptDir, "assets")}; !reflect.DeepEqual(searchPaths, want) {
+		t.Errorf("search paths %q, want %q", searchPaths, want)
+	}
+}
@@ -0,0 +1,427 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/speedata/boxesandglue/backend/bag"
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+)
+
+const sessionRegistryKey = "ets.session"
+
+// A Session is a single execution of a Lua file. Each call to Run creates a
+// fresh Lua state, so a session can be run more than once.
+type Session struct {
+	// Luafile is the name of the Lua file to execute.
+	Luafile string
+	// Source is the Lua code to execute if set. Luafile is then only the name
+	// of the code in error messages.
+	Source string
+	// FS is the file system for all files read by the script: Luafile,
+	// InitFiles, the modules loaded with require(), fonts, images and
+	// pattern files. Dir and SearchPaths are directories in FS then. If nil,
+	// the files are read from the file system of the operating system. The
+	// PDF files are always written to the file system of the operating
+	// system.
+	FS fs.FS
+	// Modules are Go modules that the script can load with require(). The
+	// functions are loaders as in lua.LState.PreloadModule.
+	Modules map[string]lua.LGFunction
+	// Exename is the name of the executable. It is used to find the startup
+	// files.
+	Exename string
+	// InitFiles are Lua files that are run after the startup files and
+	// before the Lua file.
+	InitFiles []string
+	// Args are the arguments for the Lua file, available in the Lua table arg.
+	Args []string
+	// Variables are available in the global Lua table vars.
+	Variables map[string]string
+	// Dir is the directory for relative file names of PDF files, fonts, images
+	// and hyphenation patterns. It is also added to the Lua search path. If
+	// empty, file names are relative to the current working directory.
+	Dir string
+	// CatchExit replaces os.exit with a function that stops the script
+	// instead of terminating the process. Run returns an *Error which wraps
+	// an *ExitError if the exit code is not 0.
+	CatchExit bool
+	// Sandbox removes the Lua functions that access files and other programs
+	// (the io and debug libraries, most of the os library, loadfile and
+	// dofile). Fonts, images, pattern files, Lua modules and PDF files must be
+	// in Dir (the current working directory if empty) or in one of the
+	// AllowedDirs. os.exit() stops the script like with CatchExit.
+	Sandbox bool
+	// AllowedDirs are additional directories for files in the sandbox.
+	AllowedDirs []string
+	// Context stops the script when it is done. Run returns an *Error of kind
+	// ErrorLimit in this case.
+	Context context.Context
+	// Timeout is the maximum run time of the script if greater than 0.
+	Timeout time.Duration
+	// MaxPages is the maximum number of pages the script can ship out if
+	// greater than 0.
+	MaxPages int
+	// MaxOutputSize is the maximum size of each PDF file in bytes if greater
+	// than 0.
+	MaxOutputSize int64
+	// Logger is used for log messages if set. In this case LogLevel, LogFile
+	// and LogFormat are ignored and the logger of the typesetting library
+	// (bag.Logger) is left untouched, so several sessions can run
+	// concurrently. Otherwise the session creates a logger in the first run,
+	// which is also installed as bag.Logger until Close is called.
+	Logger *zap.SugaredLogger
+	// LogLevel is the minimum level of log messages: debug (the default),
+	// info, warn or error.
+	LogLevel string
+	// LogFile is the name of the file for log messages. The default is the
+	// standard output.
+	LogFile string
+	// LogFormat is console (the default) or json.
+	LogFormat string
+	// Output receives the PDF file of the document created without a file
+	// name (document.new() or document.new("-")). Only one document per run
+	// can be written to Output. If nil, these documents are rendered into
+	// memory and finish() returns the PDF file as a string.
+	Output io.Writer
+	// OutputFile replaces the file name in document.new() if set. "-" writes
+	// the PDF file to Output. The placeholder {script} is replaced by the
+	// name of the Lua file and {name} by the file name given to
+	// document.new(), both without the extension.
+	OutputFile string
+	// SearchPaths are directories for fonts, images, pattern files and Lua
+	// modules that are not found in Dir. Fonts, images and pattern files
+	// given by file name only are also searched in the subdirectories.
+	SearchPaths []string
+	// LuaPath are entries for the Lua search path (package.path) that are
+	// searched before the default entries. An entry is a pattern like
+	// lib/?.lua or a directory, which stands for dir/?.lua and
+	// dir/?/init.lua.
+	LuaPath []string
+	// DefaultLanguage is the language tag of the bundled hyphenation patterns
+	// (such as de or en-GB) or the pattern file that is loaded as the default
+	// language of new documents.
+	DefaultLanguage string
+	// Config is the project configuration, see ApplyConfig.
+	Config *Config
+	// Dependencies contains the absolute file names of all files read during
+	// the last run: the Lua file, the startup file, required Lua modules,
+	// fonts, images and hyphenation patterns.
+	Dependencies []string
+	// Outputs contains the names of the PDF files written during the last run.
+	// The document written to Output is listed as "-".
+	Outputs []string
+	// Profile records the time spent in ets functions and Lua code if set.
+	Profile *Profile
+	logger  *zap.SugaredLogger
+	// closeLogger closes the logger created by the session and bagLogger is
+	// the logger of the typesetting library before
+	closeLogger func()
+	bagLogger   *zap.SugaredLogger
+	// lastError is the last error returned by an ets function.
+	lastError *Error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	// pages is the number of pages shipped out
+	pages int
+	// outputUsed is true if a document writes to Output
+	outputUsed bool
+	// tempDir has the copies of the files from FS, see localFile
+	tempDir string
+	// searchPaths are the SearchPaths and the directories added with
+	// document.addsearchpath()
+	searchPaths []string
+	// fileIndex has the files in the search paths by directory and file
+	// name, see find
+	fileIndex map[string]map[string]string
+}
+
+// newState creates a Lua state with the ets libraries registered and runs the
+// startup file.
+func (s *Session) newState() (*lua.LState, error) {
+	s.Dependencies = nil
+	s.Outputs = nil
+	s.lastError = nil
+	s.pages = 0
+	s.outputUsed = false
+	s.searchPaths = append([]string{}, s.SearchPaths...)
+	s.fileIndex = nil
+	if s.Logger != nil {
+		s.logger = s.Logger
+	} else if s.closeLogger == nil {
+		logger, closeLogger, err := NewLogger(s.LogLevel, s.LogFile, s.LogFormat)
+		if err != nil {
+			return nil, err
+		}
+		s.logger = logger
+		s.closeLogger = closeLogger
+		s.bagLogger = bag.Logger
+		bag.Logger = logger
+	}
+
+	l := lua.NewState()
+	s.ctx, s.cancel = s.newContext()
+	l.SetContext(s.ctx)
+	ud := l.NewUserData()
+	ud.Value = s
+	l.SetField(l.G.Registry, sessionRegistryKey, ud)
+	if s.Profile != nil {
+		s.Profile.startRun(l)
+	}
+
+	registerDocumentType(l)
+	registerNodeType(l)
+	registerJSON(l)
+	registerLuaLoader(l)
+	for name, loader := range s.Modules {
+		l.PreloadModule(name, loader)
+	}
+	s.registerArguments(l)
+	s.registerConfig(l)
+	if s.Config != nil {
+		s.addDependency(s.Config.Filename)
+	}
+	if s.CatchExit {
+		l.SetField(l.GetGlobal("os"), "exit", l.NewFunction(osExitError))
+	}
+	if s.Sandbox {
+		s.applySandbox(l)
+	}
+	s.setLuaPath(l)
+
+	if err := s.runStartupFiles(l); err != nil {
+		l.Close()
+		s.cancel()
+		return nil, err
+	}
+	return l, nil
+}
+
+// setLuaPath sets package.path to the directory of the session, the entries
+// of LuaPath, the default entries and the search paths. Search paths added
+// by the script are appended by document.addsearchpath().
+func (s *Session) setLuaPath(l *lua.LState) {
+	pkg := l.GetGlobal("package")
+	var path []string
+	if s.Dir != "" {
+		path = append(path, filepath.Join(s.Dir, "?.lua"))
+	}
+	for _, entry := range s.LuaPath {
+		if strings.Contains(entry, "?") {
+			path = append(path, entry)
+		} else {
+			path = append(path, filepath.Join(entry, "?.lua"), filepath.Join(entry, "?", "init.lua"))
+		}
+	}
+	path = append(path, lua.LVAsString(l.GetField(pkg, "path")))
+	for _, dir := range s.searchPaths {
+		path = append(path, filepath.Join(dir, "?.lua"))
+	}
+	l.SetField(pkg, "path", lua.LString(strings.Join(path, ";")))
+}
+
+// Run executes the Lua file in a new Lua state.
+func (s *Session) Run() error {
+	defer s.removeTempDir()
+	l, err := s.newState()
+	if err != nil {
+		return s.newError(err)
+	}
+	defer l.Close()
+	defer s.cancel()
+	defer s.logger.Sync()
+	if s.Profile != nil {
+		defer s.Profile.endRun(l)
+	}
+
+	if s.Source == "" {
+		s.addDependency(s.Luafile)
+	}
+	if err := s.doLuaFile(l, s.Luafile); err != nil {
+		if ee := exitCode(err); ee != nil && ee.Code == 0 {
+			return nil
+		}
+		return s.newError(err)
+	}
+
+	return nil
+}
+
+// Close closes the log file of the logger created by the session and
+// restores the logger of the typesetting library. The session can be run
+// again after Close.
+func (s *Session) Close() {
+	if s.closeLogger == nil {
+		return
+	}
+	s.closeLogger()
+	bag.Logger = s.bagLogger
+	s.closeLogger = nil
+	s.bagLogger = nil
+}
+
+// ExitError is returned by Run if the script calls os.exit() with an exit
+// code other than 0 and the session has CatchExit set.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("os.exit(%d) called", e.Code)
+}
+
+// osExitError is a replacement for os.exit which stops the script with an
+// error that contains an *ExitError.
+func osExitError(l *lua.LState) int {
+	code := 0
+	switch v := l.Get(1).(type) {
+	case lua.LBool:
+		if !v {
+			code = 1
+		}
+	case lua.LNumber:
+		code = int(v)
+	}
+	ud := l.NewUserData()
+	ud.Value = &ExitError{Code: code}
+	l.Error(ud, 1)
+	return 0
+}
+
+// exitCode returns the *ExitError in err or nil if err is not caused by
+// os.exit().
+func exitCode(err error) *ExitError {
+	if apierr, ok := err.(*lua.ApiError); ok {
+		if ud, ok := apierr.Object.(*lua.LUserData); ok {
+			if ee, ok := ud.Value.(*ExitError); ok {
+				return ee
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the file name relative to the session directory. If the
+// file does not exist there, it is searched in the search paths (but not in
+// their subdirectories, see find).
+func (s *Session) resolve(fn string) string {
+	if filepath.IsAbs(fn) {
+		return fn
+	}
+	name := fn
+	if s.Dir != "" {
+		name = filepath.Join(s.Dir, fn)
+	}
+	if len(s.searchPaths) == 0 {
+		return name
+	}
+	if _, err := s.stat(name); err == nil {
+		return name
+	}
+	for _, dir := range s.searchPaths {
+		if _, err := s.stat(filepath.Join(dir, fn)); err == nil {
+			return filepath.Join(dir, fn)
+		}
+	}
+	return name
+}
+
+// outputFilename returns the file name for document.new(filename) if
+// OutputFile is set.
+func (s *Session) outputFilename(filename string) string {
+	script := filepath.Base(s.Luafile)
+	script = strings.TrimSuffix(script, filepath.Ext(script))
+	if filename == "-" {
+		filename = script
+	}
+	name := filepath.Base(filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.NewReplacer("{script}", script, "{name}", name).Replace(s.OutputFile)
+}
+
+// addDependency records the file name fn as a file read during the run.
+// Files from FS are not recorded.
+func (s *Session) addDependency(fn string) {
+	if s.FS != nil {
+		return
+	}
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+	for _, dep := range s.Dependencies {
+		if dep == fn {
+			return
+		}
+	}
+	s.Dependencies = append(s.Dependencies, fn)
+}
+
+// sessionFromState returns the session that created the Lua state.
+func sessionFromState(l *lua.LState) *Session {
+	if ud, ok := l.GetField(l.G.Registry, sessionRegistryKey).(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*Session); ok {
+			return s
+		}
+	}
+	// should not happen, all Lua states are created by newState
+	return &Session{logger: bag.Logger}
+}
+
+// registerArguments sets the global tables arg and vars. As in the stand-alone
+// Lua interpreter arg[0] is the name of the script and arg[-1] the name of the
+// executable.
+func (s *Session) registerArguments(l *lua.LState) {
+	argtbl := l.NewTable()
+	argtbl.RawSetInt(-1, lua.LString(s.Exename))
+	argtbl.RawSetInt(0, lua.LString(s.Luafile))
+	for _, arg := range s.Args {
+		argtbl.Append(lua.LString(arg))
+	}
+	l.SetGlobal("arg", argtbl)
+
+	varstbl := l.NewTable()
+	for k, v := range s.Variables {
+		varstbl.RawSetString(k, lua.LString(v))
+	}
+	l.SetGlobal("vars", varstbl)
+}
+
+// WriteDependencies writes a Makefile rule with the PDF files written in the
+// last run as targets and the files read as prerequisites. If no PDF file was
+// written, target is used.
+func (s *Session) WriteDependencies(w io.Writer, target string) error {
+	var targets []string
+	for _, fn := range s.Outputs {
+		if fn == "-" {
+			continue
+		}
+		if abs, err := filepath.Abs(fn); err == nil {
+			fn = abs
+		}
+		targets = append(targets, makeEscape(fn))
+	}
+	if len(targets) == 0 {
+		targets = append(targets, makeEscape(target))
+	}
+	var b strings.Builder
+	b.WriteString(strings.Join(targets, " "))
+	b.WriteString(":")
+	for _, dep := range s.Dependencies {
+		b.WriteString(" \\\n  ")
+		b.WriteString(makeEscape(dep))
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// makeEscape escapes the file name for a Makefile.
+func makeEscape(fn string) string {
+	return strings.NewReplacer(" ", `\ `, "#", `\#`, "$", "$$").Replace(fn)
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/speedata/boxesandglue/backend/bag"
+)
+
+// openFiles returns the number of open file descriptors of the process.
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	return len(entries)
+}
+
+func TestSessionLogFile(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "ets.log")
+	before := bag.Logger
+	s := &Session{
+		Luafile:  "test.lua",
+		Source:   `document.info("hello")`,
+		LogFile:  logfile,
+		LogLevel: "info",
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	n := openFiles(t)
+	for i := 0; i < 3; i++ {
+		if err := s.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := openFiles(t); got != n {
+		t.Errorf("open files after runs = %d, want %d", got, n)
+	}
+	s.Close()
+	if bag.Logger != before {
+		t.Error("Close did not restore bag.Logger")
+	}
+	data, err := os.ReadFile(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "hello"); got != 4 {
+		t.Errorf("log file has %d messages, want 4:\n%s", got, data)
+	}
+}
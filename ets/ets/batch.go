@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/speedata/ets/core"
+)
+
+// batchResult is the outcome of a single script in batch mode.
+type batchResult struct {
+	luafile  string
+	err      error
+	duration time.Duration
+}
+
+// expandScripts returns the Lua files from the arguments. Arguments with glob
+// patterns are expanded, an argument starting with @ is the name of a file
+// which contains one Lua file per line. If fsys is not nil, the glob patterns
+// are expanded in fsys.
+func expandScripts(args []string, fsys fs.FS) ([]string, error) {
+	var scripts []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@"):
+			f, err := os.Open(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+					scripts = append(scripts, line)
+				}
+			}
+			f.Close()
+			if err = scanner.Err(); err != nil {
+				return nil, err
+			}
+		case strings.ContainsAny(arg, "*?["):
+			var matches []string
+			var err error
+			if fsys != nil {
+				matches, err = fs.Glob(fsys, arg)
+			} else {
+				matches, err = filepath.Glob(arg)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", arg)
+			}
+			scripts = append(scripts, matches...)
+		default:
+			scripts = append(scripts, arg)
+		}
+	}
+	return scripts, nil
+}
+
+// batch runs the sessions with the given number of parallel jobs, prints a
+// summary and returns an error if any session failed.
+func batch(sessions []*core.Session, jobs int) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	queue := make(chan *core.Session)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range queue {
+				start := time.Now()
+				err := s.Run()
+				results <- batchResult{luafile: s.Luafile, err: err, duration: time.Now().Sub(start)}
+			}
+		}()
+	}
+	go func() {
+		for _, s := range sessions {
+			queue <- s
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []batchResult
+	for res := range results {
+		if res.err != nil {
+			failed = append(failed, res)
+			fmt.Printf("FAIL %s (%s)\n", res.luafile, res.duration)
+		} else {
+			fmt.Printf("ok   %s (%s)\n", res.luafile, res.duration)
+		}
+	}
+	fmt.Printf("%d scripts, %d succeeded, %d failed\n", len(sessions), len(sessions)-len(failed), len(failed))
+	for _, res := range failed {
+		fmt.Printf("%s: %s\n", res.luafile, res.err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d scripts failed", len(failed), len(sessions))
+	}
+	return nil
+}
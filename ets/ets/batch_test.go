@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExpandScripts(t *testing.T) {
+	dir := t.TempDir()
+	for _, fn := range []string{"a.lua", "b.lua", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, fn), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(list, []byte("x.lua\n\n# comment\n  y.lua  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	memfs := fstest.MapFS{
+		"s/one.lua": &fstest.MapFile{},
+		"s/two.lua": &fstest.MapFile{},
+	}
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		fsys    fs.FS
+		want    []string
+		wantErr bool
+	}{
+		{name: "files", args: []string{"a.lua", "missing.lua"}, want: []string{"a.lua", "missing.lua"}},
+		{name: "glob", args: []string{filepath.Join(dir, "*.lua")}, want: []string{filepath.Join(dir, "a.lua"), filepath.Join(dir, "b.lua")}},
+		{name: "list file", args: []string{"@" + list, "z.lua"}, want: []string{"x.lua", "y.lua", "z.lua"}},
+		{name: "glob in fs", args: []string{"s/*.lua"}, fsys: memfs, want: []string{"s/one.lua", "s/two.lua"}},
+		{name: "no match", args: []string{filepath.Join(dir, "*.xml")}, wantErr: true},
+		{name: "missing list file", args: []string{"@" + filepath.Join(dir, "nolist")}, wantErr: true},
+		{name: "bad pattern", args: []string{"[.lua"}, wantErr: true},
+	} {
+		got, err := expandScripts(tc.args, tc.fsys)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error %v, want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
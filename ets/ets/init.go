@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/speedata/ets"
+)
+
+// initProject writes a starter project into dir: the startup file (named
+// after the executable), the sample scripts, fonts, hyphenation patterns and
+// the sample image. Existing files are not overwritten.
+func initProject(dir string, exename string) error {
+	return fs.WalkDir(ets.Skeleton, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		dest := name
+		switch {
+		case name == "ets.lua":
+			dest = startupFilename(exename)
+		case path.Dir(name) == "samples":
+			// the sample scripts go to the top level of the project
+			dest = path.Base(name)
+		}
+		dest = filepath.Join(dir, filepath.FromSlash(dest))
+		if _, err = os.Stat(dest); err == nil {
+			fmt.Printf("skip   %s (exists)\n", dest)
+			return nil
+		}
+		data, err := ets.Skeleton.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err = os.WriteFile(dest, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("create %s\n", dest)
+		return nil
+	})
+}
+
+// startupFilename returns the name of the startup file for the executable,
+// for example ets.lua for ets and ets.exe.
+func startupFilename(exename string) string {
+	return exename[:len(exename)-len(filepath.Ext(exename))] + ".lua"
+}
@@ -1,23 +1,45 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/speedata/boxesandglue/backend/bag"
 	"github.com/speedata/ets/core"
 	"github.com/speedata/optionparser"
 )
 
 var (
-	version string
+	version   string
+	buildTime string
+	// errorFormat is the format of the error report: text or json.
+	errorFormat = "text"
+	// durationOutput gets the total run time at the end of commands that
+	// render scripts.
+	durationOutput io.Writer = os.Stdout
 )
 
 const (
+	cmdCheck   = "check"
 	cmdRun     = "run"
 	cmdHelp    = "help"
+	cmdInit    = "init"
+	cmdLsp     = "lsp"
+	cmdRepl    = "repl"
+	cmdServer  = "server"
+	cmdStubs   = "stubs"
 	cmdVersion = "version"
+	cmdWatch   = "watch"
 )
 
 func dothings() error {
@@ -27,36 +49,470 @@ func dothings() error {
 		return err
 	}
 
+	// Everything after "--" is passed to the Lua script unparsed.
+	var scriptArgs []string
+	for i := 0; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if arg == "--" {
+			scriptArgs = append(scriptArgs, os.Args[i+1:]...)
+			os.Args = os.Args[:i]
+			break
+		}
+		// the option parser does not accept "-" as a parameter
+		if (arg == "-o" || arg == "--output") && i+1 < len(os.Args) && os.Args[i+1] == "-" {
+			os.Args = append(append(os.Args[:i:i], "--output=-"), os.Args[i+2:]...)
+		}
+	}
+
+	variables := map[string]string{}
+	var initFiles, allowedDirs []string
+	var sandbox bool
+	var timeout, maxPages, maxOutputSize string
+	var output, language, deps, fsName string
+	var searchPaths, luaPath []string
+	var asJSON bool
+	stubsFormat := "lua"
+	var loglevel, logfile, logformat string
+	var profile bool
+	var cpuprofile, memprofile, luaprofile string
+	listen := "localhost:8080"
+	jobs := fmt.Sprint(runtime.NumCPU())
 	op := optionparser.NewOptionParser()
-	op.Banner = "experimental typesetting system\nrun: ets somefile.lua"
+	op.Banner = "experimental typesetting system\nrun: ets script.lua [arguments]\n     ets run script.lua... [-- arguments for the scripts]"
+	op.On("--var NAME=VALUE", "Set the variable vars.NAME in the Lua script (can be repeated)", func(nameValue string) {
+		if i := strings.Index(nameValue, "="); i > 0 {
+			variables[nameValue[:i]] = nameValue[i+1:]
+		} else {
+			variables[nameValue] = ""
+		}
+	})
+	op.On("--init FILE", "Run the Lua file FILE after the startup files (can be repeated)", func(fn string) {
+		initFiles = append(initFiles, fn)
+	})
+	op.On("-o", "--output FILE", "Write the PDF file to FILE instead of the file name in document.new(), - for the standard output", &output)
+	op.On("--searchpath DIR", "Search fonts, images, pattern files and Lua modules in DIR (can be repeated)", func(dir string) {
+		searchPaths = append(searchPaths, dir)
+	})
+	op.On("--luapath PATH", "Search Lua modules with the pattern PATH (such as lib/?.lua) or in the directory PATH (can be repeated)", func(entry string) {
+		luaPath = append(luaPath, entry)
+	})
+	op.On("--fs PATH", "Read the Lua files, fonts, images and pattern files from the directory or zip archive PATH", &fsName)
+	op.On("--language LANG", "Use the bundled patterns for the language tag LANG (such as de or en-GB) or the pattern file LANG as the default language of new documents", &language)
+	op.On("--deps FILE", "Write the files read by the script as a Makefile rule to FILE", &deps)
+	op.On("--sandbox", "Run the script without access to the io and os libraries and restrict files to the current directory", &sandbox)
+	op.On("--allow-dir DIR", "Allow access to files in DIR in the sandbox (can be repeated)", func(dir string) {
+		allowedDirs = append(allowedDirs, dir)
+	})
+	op.On("--timeout DURATION", "Stop the script after DURATION (for example 30s or 2m)", &timeout)
+	op.On("--max-pages N", "Stop the script if it ships out more than N pages", &maxPages)
+	op.On("--max-output-size SIZE", "Stop the script if a PDF file gets larger than SIZE bytes (suffixes k, M and G allowed)", &maxOutputSize)
+	op.On("--loglevel LEVEL", "Set the minimum log level: debug (default), info, warn or error", &loglevel)
+	op.On("--logfile FILE", "Write log messages to FILE instead of the standard output", &logfile)
+	op.On("--logformat FORMAT", "Set the log format: console (default) or json", &logformat)
+	op.On("--listen ADDRESS", "Address for the server command (default localhost:8080, :8080 for all interfaces)", &listen)
+	op.On("--json", "Show the version information as JSON", &asJSON)
+	op.On("--format FORMAT", "Format for the stubs command: lua (default), adoc or json", &stubsFormat)
+	op.On("--error-format FORMAT", "Format of the error report: text (default) or json", &errorFormat)
+	op.On("--profile", "Show the time spent per category of ets functions and in Lua code", &profile)
+	op.On("--cpuprofile FILE", "Write a Go CPU profile to FILE", &cpuprofile)
+	op.On("--memprofile FILE", "Write a Go heap profile to FILE", &memprofile)
+	op.On("--luaprofile FILE", "Write the time spent per Lua call stack to FILE (collapsed stack format)", &luaprofile)
+	op.On("--jobs N", "Number of scripts to run in parallel with the run command (default: number of CPUs)", &jobs)
+	op.Command(cmdCheck, "Check the Lua file for unknown fields of the ets libraries")
 	op.Command(cmdVersion, "Show version information")
 	op.Command(cmdHelp, "Show usage help")
+	op.Command(cmdInit, "Create a starter project in the given directory (default: current directory)")
+	op.Command(cmdLsp, "Start a language server for editors (LSP over standard input and output)")
+	op.Command(cmdRun, "Run one or more Lua files (file names, glob patterns or @listfile)")
+	op.Command(cmdRepl, "Start an interactive Lua session")
+	op.Command(cmdServer, "Start an HTTP server that renders Lua scripts sent by POST requests in the sandbox")
+	op.Command(cmdStubs, "Write the Lua annotations of the ets libraries for editors to the given file (default: standard output)")
+	op.Command(cmdWatch, "Run the Lua file again whenever an input file changes")
 
 	err = op.Parse()
 	if err != nil {
 		return err
 	}
+	if errorFormat != "text" && errorFormat != "json" {
+		format := errorFormat
+		errorFormat = "text"
+		return fmt.Errorf("--error-format: unknown format %q, use text or json. See %s --help", format, exename)
+	}
 
 	if len(op.Extra) == 0 {
 		return fmt.Errorf("Please specify a command or file to run. See %s --help", exename)
 	}
+
+	if output == "-" {
+		// keep the standard output free for the PDF file
+		if logfile == "" {
+			logfile = "stderr"
+		}
+		durationOutput = os.Stderr
+	}
+	limits, err := parseLimits(timeout, maxPages, maxOutputSize)
+	if err != nil {
+		return err
+	}
+
+	var fsys fs.FS
+	if fsName != "" {
+		if deps != "" {
+			return fmt.Errorf("--deps can not be used with --fs")
+		}
+		if fsys, err = core.OpenFS(fsName); err != nil {
+			return err
+		}
+		if c, ok := fsys.(io.Closer); ok {
+			defer c.Close()
+		}
+	}
+
+	var prof *core.Profile
+	if profile || luaprofile != "" {
+		prof = core.NewProfile()
+		defer func() {
+			if profile {
+				prof.WriteReport(os.Stderr)
+			}
+			if luaprofile != "" {
+				if err := writeFile(luaprofile, prof.WriteLuaProfile); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}()
+	}
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err = pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if memprofile != "" {
+		defer func() {
+			runtime.GC()
+			if err := writeFile(memprofile, pprof.WriteHeapProfile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	// newSession returns a session for the Lua file and its arguments with
+	// the settings from the command line and the configuration file next to
+	// the Lua file.
+	// os.exit() stops the script with an error, so the error report names
+	// the ets function that failed before and the exit code tells its kind.
+	newSession := func(luafile string, args []string) (*core.Session, error) {
+		s := &core.Session{
+			Luafile:         luafile,
+			Exename:         exename,
+			CatchExit:       true,
+			InitFiles:       initFiles,
+			Args:            args,
+			Variables:       variables,
+			Sandbox:         sandbox,
+			AllowedDirs:     allowedDirs,
+			Timeout:         limits.Timeout,
+			MaxPages:        limits.MaxPages,
+			MaxOutputSize:   limits.MaxOutputSize,
+			LogLevel:        loglevel,
+			LogFile:         logfile,
+			LogFormat:       logformat,
+			Profile:         prof,
+			OutputFile:      output,
+			SearchPaths:     searchPaths,
+			LuaPath:         luaPath,
+			DefaultLanguage: language,
+			FS:              fsys,
+		}
+		if output == "-" {
+			s.Output = os.Stdout
+		}
+		findConfig := core.FindConfig
+		if fsys != nil {
+			findConfig = func(luafile string) (*core.Config, error) {
+				return core.FindConfigFS(fsys, luafile)
+			}
+		}
+		cfg, err := findConfig(luafile)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != nil {
+			s.ApplyConfig(cfg)
+		}
+		return s, nil
+	}
+
+	// ets runs the first argument and passes the other arguments to the
+	// script. The arguments of the run command are Lua files, the arguments
+	// for the scripts come after "--".
+	scripts := op.Extra[:1]
+	args := append(append([]string{}, op.Extra[1:]...), scriptArgs...)
+	switch op.Extra[0] {
+	case cmdCheck, cmdHelp, cmdInit, cmdLsp, cmdRepl, cmdServer, cmdStubs, cmdVersion:
+		durationOutput = io.Discard
+	}
 	switch op.Extra[0] {
 	case cmdVersion:
-		fmt.Printf("%s verson %s\n", exename, version)
+		if err := showVersion(os.Stdout, exename, asJSON); err != nil {
+			return err
+		}
 		os.Exit(0)
 	case cmdHelp:
 		op.Help()
 		os.Exit(0)
+	case cmdCheck:
+		if len(op.Extra) < 2 {
+			return fmt.Errorf("Please specify a file to check. See %s --help", exename)
+		}
+		return check(op.Extra[1:])
+	case cmdInit:
+		dir := "."
+		if len(op.Extra) > 1 {
+			dir = op.Extra[1]
+		}
+		return initProject(dir, exename)
+	case cmdLsp:
+		if err := core.ServeLSP(os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+		os.Exit(0)
+	case cmdStubs:
+		write, err := stubsWriter(stubsFormat)
+		if err != nil {
+			return err
+		}
+		if len(op.Extra) > 1 {
+			return writeFile(op.Extra[1], write)
+		}
+		if err := write(os.Stdout); err != nil {
+			return err
+		}
+		os.Exit(0)
+	case cmdRepl:
+		s, err := newSession("", args)
+		if err != nil {
+			return err
+		}
+		// os.exit() ends the repl
+		s.CatchExit = false
+		defer s.Close()
+		return s.Repl(os.Stdin, os.Stdout)
+	case cmdRun:
+		if scripts, err = expandScripts(op.Extra[1:], fsys); err != nil {
+			return err
+		}
+		args = scriptArgs
+	case cmdServer:
+		logger, closeLogger, err := core.NewLogger(loglevel, logfile, logformat)
+		if err != nil {
+			return err
+		}
+		defer closeLogger()
+		bag.Logger = logger
+		// the requests always run in the sandbox
+		sandbox = true
+		s, err := newSession("", nil)
+		if err != nil {
+			return err
+		}
+		return server(listen, &renderServer{
+			session: *s,
+			logger:  logger,
+		})
+	case cmdWatch:
+		if len(op.Extra) < 2 {
+			return fmt.Errorf("Please specify a file to watch. See %s --help", exename)
+		}
+		if fsys != nil {
+			return fmt.Errorf("watch can not be used with --fs")
+		}
+		s, err := newSession(op.Extra[1], args[1:])
+		if err != nil {
+			return err
+		}
+		return watch(s)
+	}
+	switch len(scripts) {
+	case 0:
+		return fmt.Errorf("Please specify a file to run. See %s --help", exename)
+	case 1:
+		s, err := newSession(scripts[0], args)
+		if err != nil {
+			return err
+		}
+		return runSession(s, deps)
+	}
+	if output != "" {
+		return fmt.Errorf("--output can not be used with more than one script")
+	}
+	n, err := strconv.Atoi(jobs)
+	if err != nil {
+		return fmt.Errorf("--jobs: %w", err)
+	}
+	// The sessions share one logger which is also the logger of the
+	// typesetting library, so they can run concurrently.
+	logger, closeLogger, err := core.NewLogger(loglevel, logfile, logformat)
+	if err != nil {
+		return err
+	}
+	defer closeLogger()
+	bag.Logger = logger
+	sessions := make([]*core.Session, len(scripts))
+	for i, script := range scripts {
+		if sessions[i], err = newSession(script, args); err != nil {
+			return err
+		}
+		sessions[i].Logger = logger.With("script", script)
+	}
+	err = batch(sessions, n)
+	if deps != "" {
+		if derr := writeDependencies(deps, sessions); derr != nil {
+			return derr
+		}
+	}
+	return err
+}
+
+// runSession runs the session and writes the dependencies to the file deps
+// if it is not empty.
+func runSession(s *core.Session, deps string) error {
+	defer s.Close()
+	if err := s.Run(); err != nil {
+		return err
+	}
+	if deps == "" {
+		return nil
+	}
+	return writeDependencies(deps, []*core.Session{s})
+}
+
+// writeDependencies writes a Makefile rule for each session to the file
+// deps. The target of sessions that did not write a PDF file is the file
+// deps.
+func writeDependencies(deps string, sessions []*core.Session) error {
+	return writeFile(deps, func(w io.Writer) error {
+		for _, s := range sessions {
+			if len(s.Dependencies) == 0 {
+				// the session did not run
+				continue
+			}
+			if err := s.WriteDependencies(w, deps); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// check runs core.Check on all files and prints the messages.
+func check(filenames []string) error {
+	count := 0
+	for _, fn := range filenames {
+		messages, err := core.Check(fn)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			fmt.Println(msg)
+		}
+		count += len(messages)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d problem(s) found", count)
+	}
+	return nil
+}
+
+// parseLimits returns a session with the limits from the command line
+// options.
+func parseLimits(timeout, maxPages, maxOutputSize string) (*core.Session, error) {
+	var err error
+	s := &core.Session{}
+	if timeout != "" {
+		if s.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("--timeout: %w", err)
+		}
+	}
+	if maxPages != "" {
+		if s.MaxPages, err = strconv.Atoi(maxPages); err != nil {
+			return nil, fmt.Errorf("--max-pages: %w", err)
+		}
+	}
+	if maxOutputSize != "" {
+		if s.MaxOutputSize, err = parseSize(maxOutputSize); err != nil {
+			return nil, fmt.Errorf("--max-output-size: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// parseSize returns the number of bytes for sizes like 500, 200k or 10M.
+func parseSize(size string) (int64, error) {
+	factor := int64(1)
+	switch size[len(size)-1] {
+	case 'k', 'K':
+		factor = 1 << 10
+	case 'M':
+		factor = 1 << 20
+	case 'G':
+		factor = 1 << 30
+	}
+	if factor > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	return n * factor, err
+}
+
+// writeFile creates the file and calls write with it.
+func writeFile(filename string, write func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reportError prints the error to w and returns the exit code. Errors from
+// the Lua script get a detailed report.
+func reportError(w io.Writer, err error) int {
+	var etsErr *core.Error
+	isEtsErr := errors.As(err, &etsErr)
+	if errorFormat == "json" {
+		var v interface{} = etsErr
+		if !isEtsErr {
+			v = map[string]string{"error": err.Error()}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	} else if isEtsErr {
+		fmt.Fprint(w, etsErr.Report())
+	} else {
+		fmt.Fprintln(w, err)
+	}
+	if isEtsErr {
+		return etsErr.Kind.ExitCode()
 	}
-	return core.Dothings(op.Extra[0], exename)
+	return 1
 }
 
 func main() {
 	start := time.Now()
 	err := dothings()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+		os.Exit(reportError(os.Stderr, err))
 	}
-	fmt.Println(time.Now().Sub(start))
+	fmt.Fprintln(durationOutput, time.Now().Sub(start))
 }
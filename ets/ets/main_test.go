@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"500", 500, false},
+		{"200k", 200 << 10, false},
+		{"200K", 200 << 10, false},
+		{"10M", 10 << 20, false},
+		{"2G", 2 << 30, false},
+		{"k", 0, true},
+		{"10x", 0, true},
+		{"1.5M", 0, true},
+	} {
+		got, err := parseSize(tc.size)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseSize(%q): error %v, want error %t", tc.size, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tc.size, got, tc.want)
+		}
+	}
+}
+
+// runETS runs ets with the arguments and returns the exit code and the error
+// report.
+func runETS(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	defer func(args []string) { os.Args = args }(os.Args)
+	defer func(format string) { errorFormat = format }(errorFormat)
+	os.Args = append([]string{"ets", "--logfile", filepath.Join(t.TempDir(), "ets.log")}, args...)
+	var report bytes.Buffer
+	if err := dothings(); err != nil {
+		return reportError(&report, err), report.String()
+	}
+	return 0, ""
+}
+
+func TestExitCode(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		script string
+		code   int
+		report string
+	}{
+		{"success", `os.exit(0)`, 0, ""},
+		{"os.exit after failed ets function", `
+local d, err = document.new("` + filepath.ToSlash(filepath.Join(dir, "missing", "x.pdf")) + `")
+if not d then os.exit(-1) end`, 3, "exit.lua:3: os.exit(-1) called (after document.new failed: "},
+		{"os.exit", `os.exit(7)`, 2, "exit.lua:1: os.exit(7) called"},
+		{"error", `error("stop")`, 2, "exit.lua:1: stop"},
+	} {
+		fn := filepath.Join(dir, "exit.lua")
+		if err := os.WriteFile(fn, []byte(tc.script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, report := runETS(t, fn)
+		if code != tc.code {
+			t.Errorf("%s: exit code %d, want %d", tc.name, code, tc.code)
+		}
+		if !strings.Contains(report, tc.report) {
+			t.Errorf("%s: report %q does not contain %q", tc.name, report, tc.report)
+		}
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "stop.lua")
+	if err := os.WriteFile(fn, []byte(`error("stop")`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		format string
+		code   int
+		report string
+	}{
+		{"text", 2, "script error: "},
+		{"json", 2, `"kind": "script"`},
+		{"jsno", 1, `--error-format: unknown format "jsno", use text or json`},
+		{"JSON", 1, `--error-format: unknown format "JSON"`},
+	} {
+		code, report := runETS(t, "--error-format", tc.format, fn)
+		if code != tc.code || !strings.Contains(report, tc.report) {
+			t.Errorf("--error-format %q: exit code %d and report %q, want %d and %q", tc.format, code, report, tc.code, tc.report)
+		}
+	}
+}
+
+func TestScriptArguments(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.lua")
+	b := filepath.Join(dir, "b.lua")
+	for _, fn := range []string{a, b} {
+		src := `local got = table.concat(arg, "|") assert(got == vars.want, got)`
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+		code int
+	}{
+		{"script", []string{a}, "", 0},
+		{"script with arguments", []string{a, "data.xml", "b.lua"}, "data.xml|b.lua", 0},
+		{"script with dash arguments", []string{a, "data.xml", "--", "--verbose"}, "data.xml|--verbose", 0},
+		{"run", []string{"run", a}, "", 0},
+		{"run with arguments", []string{"run", a, b, "--", "data.xml", "--verbose"}, "data.xml|--verbose", 0},
+		{"run without --", []string{"run", a, "data.xml"}, "", 1},
+	} {
+		code, report := runETS(t, append([]string{"--var", "want=" + tc.want}, tc.args...)...)
+		if code != tc.code {
+			t.Errorf("%s: exit code %d, want %d (%s)", tc.name, code, tc.code, report)
+		}
+	}
+}
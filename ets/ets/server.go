@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/speedata/ets/core"
+	"go.uber.org/zap"
+)
+
+var (
+	// maxRequestSize is the maximum size of the script including the assets.
+	maxRequestSize int64 = 256 << 20
+	// maxAssetsSize is the maximum size of the extracted assets.
+	maxAssetsSize int64 = 1 << 30
+)
+
+const (
+	// requestTimeout is the maximum run time of a script if the server has
+	// no timeout. The HTTP server timeouts don't stop the script.
+	requestTimeout = 5 * time.Minute
+	// The timeouts of the HTTP server. The write timeout includes the run
+	// time of the script.
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 5 * time.Minute
+	writeTimeout      = 10 * time.Minute
+	idleTimeout       = 2 * time.Minute
+	// requestScriptName is the file name of the script in the request
+	// directory.
+	requestScriptName = "ets-request.lua"
+)
+
+// renderServer renders PDF files from Lua scripts sent by HTTP POST requests.
+type renderServer struct {
+	// session has the settings for all requests such as the sandbox and
+	// the limits.
+	session   core.Session
+	logger    *zap.SugaredLogger
+	requestID int64
+}
+
+// server starts an HTTP server on the listen address. Each POST request gets
+// its own directory, Lua state and logger, so requests can run concurrently.
+func server(listen string, rs *renderServer) error {
+	rs.logger.Infof("Listening on %s", listen)
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           rs,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	return srv.ListenAndServe()
+}
+
+func (rs *renderServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		rs.sendError(w, http.StatusMethodNotAllowed, errors.New("only POST requests are supported"))
+		return
+	}
+	id := atomic.AddInt64(&rs.requestID, 1)
+	logger := rs.logger.With("request", id)
+
+	dir, err := ioutil.TempDir("", "ets-request")
+	if err != nil {
+		rs.sendError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if err = readRequest(r, dir); err != nil {
+		rs.sendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variables := map[string]string{}
+	for k, v := range r.URL.Query() {
+		variables[k] = v[len(v)-1]
+	}
+	s := rs.session
+	s.Luafile = filepath.Join(dir, requestScriptName)
+	s.Dir = dir
+	// the scripts come from the network
+	s.Sandbox = true
+	s.CatchExit = true
+	s.Variables = variables
+	s.Logger = logger
+	s.Context = r.Context()
+	if s.Timeout <= 0 {
+		s.Timeout = requestTimeout
+	}
+	// the document is rendered into memory, whatever file name the script
+	// uses, so the error report can be sent if the script fails. Only one
+	// document can be written to the output.
+	var pdf bytes.Buffer
+	s.Output = &pdf
+	s.OutputFile = "-"
+	if err = s.Run(); err != nil {
+		logger.Error(err)
+		rs.sendError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if len(s.Outputs) == 0 {
+		rs.sendError(w, http.StatusUnprocessableEntity, errors.New("the script did not write a PDF file"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", fmt.Sprint(pdf.Len()))
+	if _, err = pdf.WriteTo(w); err != nil {
+		logger.Error(err)
+	}
+}
+
+// sendError writes the error as a JSON object. Lua errors include the
+// location and the Lua stack traceback.
+func (rs *renderServer) sendError(w http.ResponseWriter, status int, err error) {
+	var v interface{} = map[string]string{"error": err.Error()}
+	var etsErr *core.Error
+	if errors.As(err, &etsErr) {
+		v = etsErr
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// readRequest saves the Lua script from the request in dir and extracts the
+// assets. The request is either a multipart form with the fields script and
+// (optionally) assets (a zip file) or the request body is the Lua script.
+func readRequest(r *http.Request, dir string) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return saveFile(r.Body, filepath.Join(dir, requestScriptName))
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	var hasScript bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch part.FormName() {
+		case "script":
+			hasScript = true
+			err = saveFile(part, filepath.Join(dir, requestScriptName))
+		case "assets":
+			err = extractZip(part, dir)
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if !hasScript {
+		return errors.New("the form field script is missing")
+	}
+	return nil
+}
+
+func saveFile(r io.Reader, filename string) error {
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// saveFileLimit saves at most max bytes from r in the file and returns the
+// number of bytes written. It is an error if r has more data.
+func saveFileLimit(r io.Reader, filename string, max int64) (int64, error) {
+	w, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, io.LimitReader(r, max+1))
+	if err == nil && n > max {
+		err = fmt.Errorf("%s is larger than the %d bytes left for the assets", filepath.Base(filename), max)
+	}
+	if err != nil {
+		w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// extractZip extracts the zip file from r into dir. The extracted files must
+// not be larger than maxAssetsSize in total and must not replace the script.
+func extractZip(r io.Reader, dir string) error {
+	tmp, err := ioutil.TempFile("", "ets-assets")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+	remaining := maxAssetsSize
+	for _, zf := range zr.File {
+		dest := filepath.Join(dir, zf.Name)
+		if !strings.HasPrefix(dest, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file name in zip file: %s", zf.Name)
+		}
+		if dest == filepath.Join(dir, requestScriptName) {
+			return fmt.Errorf("the zip file must not contain %s", requestScriptName)
+		}
+		if zf.FileInfo().IsDir() {
+			if err = os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		n, err := saveFileLimit(rc, dest, remaining)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
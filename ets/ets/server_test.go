@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSaveFileLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		data    string
+		max     int64
+		wantErr bool
+	}{
+		{"0123456789", 10, false},
+		{"0123456789", 20, false},
+		{"0123456789a", 10, true},
+		{"0123456789", 3, true},
+	} {
+		n, err := saveFileLimit(strings.NewReader(tc.data), filepath.Join(dir, "f"), tc.max)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("saveFileLimit(%q, %d): error %v, want error %t", tc.data, tc.max, err, tc.wantErr)
+		}
+		if want := fmt.Sprintf("f is larger than the %d bytes left for the assets", tc.max); tc.wantErr && err != nil && err.Error() != want {
+			t.Errorf("saveFileLimit(%q, %d): error %q, want %q", tc.data, tc.max, err, want)
+		}
+		if !tc.wantErr && n != int64(len(tc.data)) {
+			t.Errorf("saveFileLimit(%q, %d) = %d, want %d", tc.data, tc.max, n, len(tc.data))
+		}
+	}
+}
+
+const pdfScript = `
+local d = assert(document.new())
+d.newpage()
+d.currentpage().shipout()
+assert(d.finish())
+`
+
+// zipFile returns a zip file with the files.
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// multipartRequest returns a POST request with the script and the assets as a
+// multipart form.
+func multipartRequest(t *testing.T, script string, assets []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if assets != nil {
+		w, _ := mw.CreateFormFile("assets", "assets.zip")
+		w.Write(assets)
+	}
+	w, _ := mw.CreateFormFile("script", "script.lua")
+	io.WriteString(w, script)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/?customer=acme", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestServeHTTP(t *testing.T) {
+	defer func(max int64) { maxRequestSize = max }(maxRequestSize)
+	maxRequestSize = 1 << 20
+	rs := &renderServer{logger: zap.NewNop().Sugar()}
+	outside := filepath.Join(os.TempDir(), "ets-evil.lua")
+	for _, tc := range []struct {
+		name    string
+		request *http.Request
+		status  int
+		// body is the start of the response or a field of the JSON error
+		body string
+	}{
+		{
+			name:    "script",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pdfScript)),
+			status:  http.StatusOK,
+			body:    "%PDF-",
+		},
+		{
+			name: "script with assets",
+			request: multipartRequest(t, `local helper = require("helper")
+assert(helper.customer() == "acme")`+pdfScript, zipFile(t, map[string]string{
+				"helper.lua": `return { customer = function() return vars.customer end }`,
+			})),
+			status: http.StatusOK,
+			body:   "%PDF-",
+		},
+		{
+			name:    "zip with path outside the request directory",
+			request: multipartRequest(t, pdfScript, zipFile(t, map[string]string{"../" + filepath.Base(outside): "os.exit(1)"})),
+			status:  http.StatusBadRequest,
+			body:    `"error":"invalid file name in zip file: ../ets-evil.lua"`,
+		},
+		{
+			name:    "zip with the request script",
+			request: multipartRequest(t, pdfScript, zipFile(t, map[string]string{requestScriptName: "error('replaced')"})),
+			status:  http.StatusBadRequest,
+			body:    `"error":"the zip file must not contain ets-request.lua"`,
+		},
+		{
+			name:    "script too large",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pdfScript+strings.Repeat("-- padding\n", 1<<17))),
+			status:  http.StatusBadRequest,
+			body:    `"error":"http: request body too large"`,
+		},
+		{
+			name:    "GET",
+			request: httptest.NewRequest(http.MethodGet, "/", nil),
+			status:  http.StatusMethodNotAllowed,
+			body:    `"error":"only POST requests are supported"`,
+		},
+		{
+			name:    "failing script",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader("local x = 1\nerror('boom')\n")),
+			status:  http.StatusUnprocessableEntity,
+			body:    `"kind":"script","error":"boom",`,
+		},
+		{
+			name:    "no document",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`local d = document.new()`)),
+			status:  http.StatusUnprocessableEntity,
+			body:    `"error":"the script did not write a PDF file"`,
+		},
+		{
+			name:    "two documents",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pdfScript+pdfScript)),
+			status:  http.StatusUnprocessableEntity,
+			body:    `"function":"document.new"`,
+		},
+		{
+			name:    "os.execute",
+			request: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`os.execute("true")`)),
+			status:  http.StatusUnprocessableEntity,
+			body:    `"kind":"script"`,
+		},
+	} {
+		w := httptest.NewRecorder()
+		rs.ServeHTTP(w, tc.request)
+		resp := w.Result()
+		body := w.Body.String()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d (%s)", tc.name, resp.StatusCode, tc.status, body)
+			continue
+		}
+		if tc.status == http.StatusOK {
+			if ct := resp.Header.Get("Content-Type"); ct != "application/pdf" || !strings.HasPrefix(body, tc.body) {
+				t.Errorf("%s: got %s %.20q, want a PDF file", tc.name, ct, body)
+			}
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" || !json.Valid(w.Body.Bytes()) || !strings.Contains(body, tc.body) {
+			t.Errorf("%s: got %s %s, want JSON with %s", tc.name, ct, body, tc.body)
+		}
+	}
+	if _, err := os.Stat(outside); err == nil {
+		os.Remove(outside)
+		t.Errorf("the zip file was extracted to %s", outside)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/speedata/ets/core"
+)
+
+// stubsWriter returns the function that writes the description of the ets
+// libraries in the format: lua for the annotations of the Lua language
+// server, adoc for the reference in the manual or json.
+func stubsWriter(format string) (func(io.Writer) error, error) {
+	switch format {
+	case "lua":
+		return core.WriteStubs, nil
+	case "adoc":
+		return core.WriteReference, nil
+	case "json":
+		return func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(core.API())
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q for the stubs command (lua, adoc or json)", format)
+}
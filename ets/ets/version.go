@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// versionInfo is the build information shown by the version command.
+type versionInfo struct {
+	Version    string          `json:"version"`
+	GoVersion  string          `json:"go_version"`
+	Platform   string          `json:"platform"`
+	Revision   string          `json:"revision,omitempty"`
+	Modified   bool            `json:"modified,omitempty"`
+	CommitTime string          `json:"commit_time,omitempty"`
+	BuildTime  string          `json:"build_time,omitempty"`
+	Modules    []moduleVersion `json:"modules"`
+}
+
+// moduleVersion is a Go module compiled into the binary.
+type moduleVersion struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Replace is the replacement module if the module is replaced in go.mod.
+	Replace string `json:"replace,omitempty"`
+}
+
+// getVersionInfo returns the version and build time set with -X main.version
+// and -X main.buildTime and the build information from the binary.
+func getVersionInfo() versionInfo {
+	vi := versionInfo{
+		Version:   version,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Modules:   []moduleVersion{},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return vi
+	}
+	if vi.Version == "" {
+		vi.Version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			vi.Revision = setting.Value
+		case "vcs.time":
+			vi.CommitTime = setting.Value
+		case "vcs.modified":
+			vi.Modified = setting.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		mv := moduleVersion{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			mv.Replace = dep.Replace.Path + " " + dep.Replace.Version
+		}
+		vi.Modules = append(vi.Modules, mv)
+	}
+	return vi
+}
+
+// showVersion writes the version information as text or JSON.
+func showVersion(w io.Writer, exename string, asJSON bool) error {
+	vi := getVersionInfo()
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vi)
+	}
+	fmt.Fprintf(w, "%s version %s\n", exename, vi.Version)
+	fmt.Fprintf(w, "go version: %s %s\n", vi.GoVersion, vi.Platform)
+	if vi.Revision != "" {
+		modified := ""
+		if vi.Modified {
+			modified = " (modified)"
+		}
+		fmt.Fprintf(w, "revision: %s%s\n", vi.Revision, modified)
+	}
+	if vi.CommitTime != "" {
+		fmt.Fprintf(w, "commit time: %s\n", vi.CommitTime)
+	}
+	if vi.BuildTime != "" {
+		fmt.Fprintf(w, "build time: %s\n", vi.BuildTime)
+	}
+	fmt.Fprintln(w, "modules:")
+	for _, mv := range vi.Modules {
+		if mv.Replace != "" {
+			fmt.Fprintf(w, "  %s %s => %s\n", mv.Path, mv.Version, mv.Replace)
+		} else {
+			fmt.Fprintf(w, "  %s %s\n", mv.Path, mv.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/speedata/ets/core"
+)
+
+const watchInterval = 500 * time.Millisecond
+
+// modtimes returns the modification times of all given files. Files that
+// cannot be read get the zero time, so creating them later counts as a change.
+func modtimes(files []string) map[string]time.Time {
+	m := make(map[string]time.Time, len(files))
+	for _, fn := range files {
+		if fi, err := os.Stat(fn); err == nil {
+			m[fn] = fi.ModTime()
+		} else {
+			m[fn] = time.Time{}
+		}
+	}
+	return m
+}
+
+// changed returns the first file in old whose modification time differs from
+// the current one.
+func changed(old map[string]time.Time) (string, bool) {
+	for fn, t := range old {
+		var cur time.Time
+		if fi, err := os.Stat(fn); err == nil {
+			cur = fi.ModTime()
+		}
+		if !cur.Equal(t) {
+			return fn, true
+		}
+	}
+	return "", false
+}
+
+// watch runs the session and runs it again whenever the Lua file or any file
+// read during the run changes. The modification times are taken before each
+// run, so a file saved during a run starts the next run. Errors are reported
+// as by the run command, watch only returns when the process is terminated.
+func watch(s *core.Session) error {
+	// the dependencies are absolute file names
+	luafile, err := filepath.Abs(s.Luafile)
+	if err != nil {
+		return err
+	}
+	files := []string{luafile}
+	for {
+		times := modtimes(files)
+		start := time.Now()
+		if err := s.Run(); err != nil {
+			reportError(os.Stderr, err)
+		} else {
+			fmt.Println(time.Now().Sub(start))
+		}
+		if len(s.Dependencies) > 0 {
+			files = s.Dependencies
+		}
+		for _, fn := range files {
+			if _, ok := times[fn]; ok {
+				continue
+			}
+			// read for the first time: a change during the run counts
+			if fi, err := os.Stat(fn); err == nil && !fi.ModTime().After(start) {
+				times[fn] = fi.ModTime()
+			} else {
+				times[fn] = time.Time{}
+			}
+		}
+		fmt.Printf("Watching %d files for changes, press Ctrl-C to stop\n", len(times))
+		for {
+			if fn, ok := changed(times); ok {
+				fmt.Printf("%s changed, running %s\n", fn, s.Luafile)
+				break
+			}
+			time.Sleep(watchInterval)
+		}
+	}
+}
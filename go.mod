@@ -3,6 +3,7 @@ module github.com/speedata/ets
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/speedata/boxesandglue v0.0.0-20211210131222-4caeb0a48247
 	github.com/speedata/optionparser v1.0.0
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
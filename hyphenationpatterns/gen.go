@@ -0,0 +1,156 @@
+//go:build ignore
+// +build ignore
+
+// gen copies the pattern files of the bundled languages from the hyph-utf8
+// project into this directory. The hyphenation exceptions of a language
+// (hyph-<tag>.hyp.txt) are appended to its patterns as patterns for the whole
+// word, because the pattern files are the only input of the hyphenation
+// library.
+//
+// Run it in this directory with
+//
+//	go run gen.go [-src DIR] [-ref REF]
+//
+// Without -src the tex-hyphen repository is downloaded from GitHub at REF
+// (default master). DIR is a checkout of the tex-hyphen repository or the
+// hyph-utf8 directory from CTAN.
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/speedata/ets/hyphenationpatterns"
+)
+
+// patternDir is the directory of the pattern files in hyph-utf8.
+const patternDir = "tex/generic/hyph-utf8/patterns/txt"
+
+func main() {
+	src := flag.String("src", "", "tex-hyphen checkout or hyph-utf8 directory (default: download from GitHub)")
+	ref := flag.String("ref", "master", "branch or tag of tex-hyphen to download")
+	flag.Parse()
+	var fsys fs.FS
+	var err error
+	if *src != "" {
+		fsys = os.DirFS(*src)
+	} else {
+		fsys, err = download("https://github.com/hyphenation/tex-hyphen/archive/" + *ref + ".zip")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	dir, err := findPatternDir(fsys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, lang := range hyphenationpatterns.Languages {
+		if err = copyPatterns(fsys, dir, lang.Tag); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// download returns the zip file at url as a file system.
+func download(url string) (fs.FS, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// findPatternDir returns the directory with the pattern files in fsys.
+func findPatternDir(fsys fs.FS) (string, error) {
+	var found string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path == patternDir || strings.HasSuffix(path, "/"+patternDir)) {
+			found = path
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err == nil && found == "" {
+		err = errors.New(patternDir + " not found")
+	}
+	return found, err
+}
+
+// copyPatterns writes the patterns and the exceptions of the language to
+// hyph-<tag>.pat.txt.
+func copyPatterns(fsys fs.FS, dir string, tag string) error {
+	patterns, err := fs.ReadFile(fsys, dir+"/hyph-"+tag+".pat.txt")
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.Write(patterns)
+	if len(patterns) > 0 && patterns[len(patterns)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	exceptions, err := fs.ReadFile(fsys, dir+"/hyph-"+tag+".hyp.txt")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(exceptions))
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		b.WriteString(exceptionPattern(scanner.Text()))
+		b.WriteByte('\n')
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile("hyph-"+tag+".pat.txt", b.Bytes(), 0o644)
+}
+
+// exceptionPattern returns the pattern for the hyphenated word, such as
+// .t8a9b8l8e. for ta-ble. The odd value 9 allows a hyphen, the even value 8
+// prevents the hyphens of other patterns.
+func exceptionPattern(word string) string {
+	var b strings.Builder
+	b.WriteByte('.')
+	letters := []rune(strings.Replace(word, "-", "", -1))
+	hyphens := map[int]bool{}
+	n := 0
+	for _, r := range word {
+		if r == '-' {
+			hyphens[n] = true
+		} else {
+			n++
+		}
+	}
+	for i, r := range letters {
+		if i > 0 {
+			if hyphens[i] {
+				b.WriteByte('9')
+			} else {
+				b.WriteByte('8')
+			}
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('.')
+	return b.String()
+}
@@ -0,0 +1,130 @@
+// Package hyphenationpatterns contains the hyphenation patterns from the
+// hyph-utf8 project that are bundled with ets and the settings for each
+// language.
+package hyphenationpatterns
+
+//go:generate go run gen.go
+
+import (
+	"embed"
+	"regexp"
+	"strings"
+)
+
+// FS contains the pattern files hyph-<tag>.pat.txt.
+//
+//go:embed *.pat.txt
+var FS embed.FS
+
+// Language describes the bundled patterns for a language.
+type Language struct {
+	// Tag is the language tag of the pattern file, such as de-1996.
+	Tag string
+	// Name is the English name of the language.
+	Name string
+	// Lefthyphenmin and Righthyphenmin are the minimum number of letters
+	// before and after a hyphen.
+	Lefthyphenmin  int
+	Righthyphenmin int
+}
+
+// Filename returns the name of the pattern file in FS.
+func (lang *Language) Filename() string {
+	return "hyph-" + lang.Tag + ".pat.txt"
+}
+
+// Languages are the bundled languages sorted by tag.
+var Languages = []*Language{
+	{"af", "Afrikaans", 1, 2},
+	{"as", "Assamese", 1, 1},
+	{"be", "Belarusian", 2, 2},
+	{"bg", "Bulgarian", 2, 2},
+	{"bn", "Bengali", 1, 1},
+	{"cu", "Church Slavonic", 1, 2},
+	{"cy", "Welsh", 2, 3},
+	{"da", "Danish", 2, 2},
+	{"de-1901", "German, traditional spelling", 2, 2},
+	{"de-1996", "German, reformed spelling", 2, 2},
+	{"de-ch-1901", "Swiss German, traditional spelling", 2, 2},
+	{"el", "Modern Greek", 1, 1},
+	{"en-gb", "British English", 2, 3},
+	{"en-us", "American English", 2, 3},
+	{"es", "Spanish", 2, 2},
+	{"et", "Estonian", 2, 3},
+	{"eu", "Basque", 2, 2},
+	{"fr", "French", 2, 2},
+	{"ga", "Irish", 2, 3},
+	{"gl", "Galician", 2, 2},
+	{"gu", "Gujarati", 1, 1},
+	{"hi", "Hindi", 1, 1},
+	{"hr", "Croatian", 2, 2},
+	{"hu", "Hungarian", 2, 2},
+	{"hy", "Armenian", 1, 2},
+	{"it", "Italian", 2, 2},
+	{"ka", "Georgian", 1, 2},
+	{"kn", "Kannada", 1, 1},
+	{"la", "Latin", 2, 2},
+	{"lt", "Lithuanian", 2, 2},
+	{"lv", "Latvian", 2, 2},
+	{"ml", "Malayalam", 1, 1},
+	{"mn-cyrl", "Mongolian", 2, 2},
+	{"mr", "Marathi", 1, 1},
+	{"mul-ethi", "Ethiopic", 1, 1},
+	{"nb", "Norwegian Bokmål", 2, 2},
+	{"nl", "Dutch", 2, 2},
+	{"nn", "Norwegian Nynorsk", 2, 2},
+	{"or", "Oriya", 1, 1},
+	{"pa", "Punjabi", 1, 1},
+	{"pt", "Portuguese", 2, 3},
+	{"ru", "Russian", 2, 2},
+	{"sl", "Slovenian", 2, 2},
+	{"sq", "Albanian", 2, 2},
+	{"sv", "Swedish", 2, 2},
+	{"ta", "Tamil", 1, 1},
+	{"te", "Telugu", 1, 1},
+	{"tk", "Turkmen", 2, 2},
+	{"uk", "Ukrainian", 2, 2},
+}
+
+// aliases map language tags to the tags of the pattern files. Swiss German
+// uses the reformed spelling without ß, which the patterns of de-1996 cover,
+// de-ch-1901 has to be asked for explicitly.
+var aliases = map[string]string{
+	"de":    "de-1996",
+	"de-ch": "de-1996",
+	"en":    "en-us",
+	"mn":    "mn-cyrl",
+	"no":    "nb",
+}
+
+var tagRE = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{1,8})*$`)
+
+// IsTag reports whether s looks like a BCP 47 language tag such as de or
+// en-GB.
+func IsTag(s string) bool {
+	return tagRE.MatchString(s)
+}
+
+// Find returns the language for the BCP 47 language tag or nil if there are
+// no bundled patterns for the language. Tags are compared case-insensitively
+// and subtags that are not known are removed from the end, so de-DE finds the
+// patterns for German (de-1996) and pt-BR the patterns for Portuguese.
+func Find(tag string) *Language {
+	tag = strings.ToLower(strings.Replace(tag, "_", "-", -1))
+	for tag != "" {
+		if t, ok := aliases[tag]; ok {
+			tag = t
+		}
+		for _, lang := range Languages {
+			if lang.Tag == tag {
+				return lang
+			}
+		}
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package hyphenationpatterns
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// TestFind checks the tag resolution: tags are compared case-insensitively
+// with _ or -, unknown subtags are removed from the end and the aliases map
+// de and de-CH to the reformed German spelling (de-1996), en to American
+// English (en-us), mn to Mongolian in Cyrillic (mn-cyrl) and no to Norwegian
+// Bokmål (nb).
+func TestFind(t *testing.T) {
+	for _, tc := range []struct {
+		tag  string
+		want string
+	}{
+		{"de", "de-1996"},
+		{"de-DE", "de-1996"},
+		{"de-1901", "de-1901"},
+		{"de-CH", "de-1996"},
+		{"de_ch", "de-1996"},
+		{"de-CH-1996", "de-1996"},
+		{"de-CH-1901", "de-ch-1901"},
+		{"de-ch-1901", "de-ch-1901"},
+		{"en", "en-us"},
+		{"en-US", "en-us"},
+		{"EN-gb", "en-gb"},
+		{"pt-BR", "pt"},
+		{"no", "nb"},
+		{"nn", "nn"},
+		{"mn", "mn-cyrl"},
+		{"xx", ""},
+		{"xx-de", ""},
+		{"", ""},
+	} {
+		var got string
+		if lang := Find(tc.tag); lang != nil {
+			got = lang.Tag
+		}
+		if got != tc.want {
+			t.Errorf("Find(%q) = %q, want %q", tc.tag, got, tc.want)
+		}
+	}
+}
+
+func TestIsTag(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want bool
+	}{
+		{"de", true},
+		{"en-GB", true},
+		{"de_CH", true},
+		{"de-ch-1901", true},
+		{"mul-ethi", true},
+		{"d", false},
+		{"german", false},
+		{"hyph-de.pat.txt", false},
+		{"patterns/de", false},
+		{"de-", false},
+		{"", false},
+	} {
+		if got := IsTag(tc.s); got != tc.want {
+			t.Errorf("IsTag(%q) = %t, want %t", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	for _, lang := range Languages {
+		if _, err := fs.Stat(FS, lang.Filename()); err != nil {
+			t.Errorf("%s: %v", lang.Tag, err)
+		}
+	}
+}
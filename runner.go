@@ -0,0 +1,176 @@
+package ets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/speedata/ets/core"
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+)
+
+// Error is the error returned by Runner.Run if the Lua script fails. It has
+// the kind of error, the location in the script, the ets function involved
+// and the Lua stack traceback.
+type Error = core.Error
+
+// ErrorKind is the category of an Error.
+type ErrorKind = core.ErrorKind
+
+// The kinds of errors.
+const (
+	// ErrorScript is an error in the Lua script, such as a syntax error or a
+	// wrong argument to an ets function.
+	ErrorScript = core.ErrorScript
+	// ErrorIO is an error reading or writing a file.
+	ErrorIO = core.ErrorIO
+	// ErrorPDF is an error writing the PDF file.
+	ErrorPDF = core.ErrorPDF
+	// ErrorLimit is returned if the script exceeds a limit or the context is
+	// done.
+	ErrorLimit = core.ErrorLimit
+)
+
+// ExitError is the underlying error of an Error if the script calls os.exit()
+// with an exit code other than 0.
+type ExitError = core.ExitError
+
+// OpenFS returns the file system for Runner.FS: the directory name or the
+// zip archive name (if the file name ends with .zip). The file system of a
+// zip archive implements io.Closer and must be closed by the caller.
+func OpenFS(name string) (fs.FS, error) {
+	return core.OpenFS(name)
+}
+
+// MemFS returns a file system for Runner.FS with the files in memory. The keys
+// are the file names with slashes as separators, such as fonts/regular.ttf.
+func MemFS(files map[string][]byte) (fs.FS, error) {
+	return core.MemFS(files)
+}
+
+// A Runner runs ets Lua scripts in a Go program. The fields are the options of
+// the runs. A Runner can run several scripts concurrently as long as the
+// fields are not changed.
+//
+//	r := ets.Runner{
+//		Source:    `local d = document.new() ... d.finish()`,
+//		Output:    w,
+//		Variables: map[string]string{"customer": "acme"},
+//	}
+//	if _, err := r.Run(ctx); err != nil {
+//		var etsErr *ets.Error
+//		if errors.As(err, &etsErr) {
+//			log.Print(etsErr.Report())
+//		}
+//	}
+type Runner struct {
+	// Script is the name of the Lua file to run. If Source is set, Script is
+	// only the name of the code in error messages (default script.lua).
+	Script string
+	// Source is the Lua code to run.
+	Source string
+	// Dir is the directory for relative file names of PDF files, fonts,
+	// images and hyphenation patterns. If empty, file names are relative to
+	// the current working directory.
+	Dir string
+	// FS is the file system for all files read by the script: Script,
+	// InitFiles, the modules loaded with require(), fonts, images and pattern
+	// files, see OpenFS and MemFS. If nil, the files are read from the file
+	// system of the operating system.
+	FS fs.FS
+	// Output receives the PDF file of documents created without a file name
+	// (document.new()). If nil, these documents are rendered into memory and
+	// finish() returns the PDF file as a string.
+	Output io.Writer
+	// Logger gets the log messages of the script. If nil, the messages of the
+	// script are discarded. The typesetting library logs to the package
+	// variable bag.Logger, which writes to stderr by default. Run does not
+	// change bag.Logger, because Runners can run concurrently; set it before
+	// running scripts to redirect or silence these messages.
+	Logger *zap.SugaredLogger
+	// Modules are Go modules that the script can load with require(). The
+	// functions are loaders as in lua.LState.PreloadModule.
+	Modules map[string]lua.LGFunction
+	// Variables are available in the global Lua table vars.
+	Variables map[string]string
+	// Args are available in the Lua table arg.
+	Args []string
+	// InitFiles are Lua files that are run before the script.
+	InitFiles []string
+	// SearchPaths are directories for fonts, images, pattern files and Lua
+	// modules that are not found in Dir.
+	SearchPaths []string
+	// LuaPath are entries for the Lua search path (package.path), see
+	// core.Session.
+	LuaPath []string
+	// DefaultLanguage is the language tag of the bundled hyphenation patterns
+	// (such as de or en-GB) or the pattern file that is loaded as the default
+	// language of new documents.
+	DefaultLanguage string
+	// Sandbox restricts the script, see core.Session.
+	Sandbox bool
+	// AllowedDirs are additional directories for files in the sandbox.
+	AllowedDirs []string
+	// Timeout is the maximum run time of the script if greater than 0.
+	Timeout time.Duration
+	// MaxPages is the maximum number of pages if greater than 0.
+	MaxPages int
+	// MaxOutputSize is the maximum size of each PDF file in bytes if greater
+	// than 0.
+	MaxOutputSize int64
+}
+
+// Result has the files of a run.
+type Result struct {
+	// Outputs are the names of the PDF files written by the script. The
+	// document written to Runner.Output is listed as "-".
+	Outputs []string
+	// Dependencies are the absolute names of the files read by the script
+	// from the file system of the operating system.
+	Dependencies []string
+}
+
+// Run runs the script. The script is stopped when ctx is done. If the script
+// fails, the error is an *Error. The script can not terminate the program,
+// os.exit() only stops the script. The Result is returned even if the script
+// fails.
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	if r.Script == "" && r.Source == "" {
+		return nil, errors.New("ets: no script to run, set Script or Source")
+	}
+	script := r.Script
+	if script == "" {
+		script = "script.lua"
+	}
+	logger := r.Logger
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	s := &core.Session{
+		Luafile:         script,
+		Source:          r.Source,
+		FS:              r.FS,
+		Modules:         r.Modules,
+		Dir:             r.Dir,
+		Output:          r.Output,
+		Logger:          logger,
+		Variables:       r.Variables,
+		Args:            r.Args,
+		InitFiles:       r.InitFiles,
+		SearchPaths:     r.SearchPaths,
+		LuaPath:         r.LuaPath,
+		DefaultLanguage: r.DefaultLanguage,
+		Sandbox:         r.Sandbox,
+		AllowedDirs:     r.AllowedDirs,
+		Timeout:         r.Timeout,
+		MaxPages:        r.MaxPages,
+		MaxOutputSize:   r.MaxOutputSize,
+		Context:         ctx,
+		CatchExit:       true,
+	}
+	err := s.Run()
+	return &Result{Outputs: s.Outputs, Dependencies: s.Dependencies}, err
+}
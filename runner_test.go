@@ -0,0 +1,127 @@
+package ets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunOutput(t *testing.T) {
+	var out bytes.Buffer
+	r := Runner{
+		Source: `
+local d = assert(document.new())
+d.newpage()
+d.currentpage().shipout()
+assert(d.finish())
+assert(vars.customer == "acme" and arg[1] == "data.xml")`,
+		Output:    &out,
+		Variables: map[string]string{"customer": "acme"},
+		Args:      []string{"data.xml"},
+	}
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Outputs, []string{"-"}) {
+		t.Errorf("Outputs = %q, want [-]", res.Outputs)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("%PDF-")) {
+		t.Errorf("output is not a PDF file: %.20q", out.String())
+	}
+}
+
+func TestRunError(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		runner   Runner
+		kind     ErrorKind
+		message  string
+		function string
+		line     int
+		exitCode int
+	}{
+		{
+			name:    "script error",
+			runner:  Runner{Script: "fail.lua", Source: "local x = 1\nerror(\"stop\")"},
+			kind:    ErrorScript,
+			message: "stop",
+			line:    2,
+		},
+		{
+			name:     "ets function",
+			runner:   Runner{Source: `assert(document.new("` + filepath.ToSlash(filepath.Join(dir, "missing", "x.pdf")) + `"))`},
+			kind:     ErrorIO,
+			function: "document.new",
+			line:     1,
+		},
+		{
+			name:     "os.exit",
+			runner:   Runner{Source: "os.exit(4)"},
+			kind:     ErrorScript,
+			message:  "os.exit(4) called",
+			line:     1,
+			exitCode: 4,
+		},
+		{
+			name:   "timeout",
+			runner: Runner{Source: "while true do end", Timeout: 50 * time.Millisecond},
+			kind:   ErrorLimit,
+			line:   1,
+		},
+		{
+			name:   "missing script",
+			runner: Runner{Script: filepath.Join(dir, "missing.lua")},
+			kind:   ErrorIO,
+		},
+	} {
+		_, err := tc.runner.Run(context.Background())
+		var etsErr *Error
+		if !errors.As(err, &etsErr) {
+			t.Errorf("%s: error %v (%T), want *Error", tc.name, err, err)
+			continue
+		}
+		if etsErr.Kind != tc.kind {
+			t.Errorf("%s: kind %s, want %s", tc.name, etsErr.Kind, tc.kind)
+		}
+		if tc.message != "" && etsErr.Message != tc.message {
+			t.Errorf("%s: message %q, want %q", tc.name, etsErr.Message, tc.message)
+		}
+		if etsErr.Function != tc.function {
+			t.Errorf("%s: function %q, want %q", tc.name, etsErr.Function, tc.function)
+		}
+		if etsErr.Line != tc.line {
+			t.Errorf("%s: line %d, want %d", tc.name, etsErr.Line, tc.line)
+		}
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) != (tc.exitCode != 0) || exitErr != nil && exitErr.Code != tc.exitCode {
+			t.Errorf("%s: exit error %v, want exit code %d", tc.name, exitErr, tc.exitCode)
+		}
+	}
+}
+
+func TestRunNoScript(t *testing.T) {
+	var r Runner
+	if _, err := r.Run(context.Background()); err == nil {
+		t.Error("Run without script: no error")
+	}
+}
+
+func TestRunScriptFS(t *testing.T) {
+	fsys, err := MemFS(map[string][]byte{
+		"main.lua":       []byte(`local m = require("lib.helper") assert(m.answer == 42)`),
+		"lib/helper.lua": []byte(`return {answer = 42}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Runner{Script: "main.lua", FS: fsys}
+	if _, err = r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,53 @@
+-- This document uses the high level interface with document/mknodes.
+-- When you need more control, you can create all nodes yourself and
+-- output these on the page.
+document.info("Reading highlevel.lua")
+local d = document.new("highlevel.pdf")
+
+local lang_en, msg = d.loadpattern("hyphenationpatterns/hyph-en-us.pat.txt")
+if not lang_en then
+    print(msg)
+    os.exit(-1)
+end
+d.defaultlanguage = lang_en
+
+-- font sources
+local reg = {name="crimson pro regular",source="fonts/CrimsonPro-Regular.ttf"}
+local bold = {name="crimson pro bold",source="fonts/CrimsonPro-Bold.ttf"}
+
+local ff = d.newfontfamily("textfamily")
+-- font source, weight (0-1000), style (normal,italic)
+ff.addmember(reg,400,"normal")
+ff.addmember(bold,700,"normal")
+
+
+local te = {
+    settings = { fontfamily = ff },
+    "Hello ",
+    { settings = { weight = 700, color = "rebeccapurple"},
+      {"nice"}
+    },
+    " world",
+ }
+
+local head,tail = d.mknodes(te)
+d.hyphenate(head)
+node.append_lineend(tail)
+
+local param = {
+    hsize = document.sp("134pt"),
+    lineheight = document.sp("12pt"),
+}
+
+local vl = node.linebreak(head,param)
+
+d.outputat(document.sp("1cm"),document.sp("27cm"),vl)
+d.currentpage().shipout()
+
+local ok, msg = d.finish()
+if not ok then
+    print(msg)
+    os.exit(-1)
+end
+
+document.info("Reading highlevel.lua...done")